@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/dyndns"
+	"github.com/willfantom/cloudflaere/pkg/gateway"
+	"github.com/willfantom/cloudflaere/pkg/ipaddr"
+	"github.com/willfantom/cloudflaere/pkg/match"
+	"github.com/willfantom/cloudflaere/pkg/netif"
+	"github.com/willfantom/cloudflaere/pkg/stun"
+	"github.com/willfantom/cloudflaere/pkg/wtfip"
+)
+
+// pushServer holds the addresses pushed by routers when the dyndns server is
+// enabled.
+var pushServer *dyndns.Server
+
+// dampers hold the address in use for each family (keyed by ipv6) while a
+// change is being confirmed.
+var dampers = make(map[bool]*ipaddr.Damper)
+
+// lookupAddresses resolves the address of each enabled family, keyed by the
+// record type it is used for. Each family is resolved on its own, so should
+// one fail, the others are still returned. The record types of any families
+// that failed are returned too, so that records of those types can be left
+// as they are.
+func lookupAddresses() (map[string]netip.Addr, map[string]bool) {
+	addresses := make(map[string]netip.Addr)
+	unavailable := make(map[string]bool)
+	if viper.GetBool("ddns.ipv4") {
+		ip, err := resolveAddress(false)
+		if err != nil {
+			logrus.WithError(err).Errorln("could not fetch ipv4 address")
+			unavailable["A"] = true
+		} else {
+			addresses["A"] = ip
+			logrus.WithField("address", ip.StringExpanded()).Infoln("address v4 fetched")
+		}
+	}
+	if viper.GetBool("ddns.ipv6") {
+		ip, err := resolveAddress(true)
+		if err != nil {
+			logrus.WithError(err).Errorln("could not fetch ipv6 address")
+			unavailable["AAAA"] = true
+		} else {
+			addresses["AAAA"] = ip
+			logrus.WithField("address", ip.StringExpanded()).Infoln("address v6 fetched")
+		}
+	}
+	return addresses, unavailable
+}
+
+// resolveAddress looks up the current public address of the given family and
+// checks that it is safe to publish. Addresses in reserved ranges are rejected
+// (unless allowed in the config, or taken from an interface scope explicitly
+// chosen in the config), and changes are only accepted once they have
+// been seen ddns.confirmations times in a row. Addresses pushed to the dyndns
+// server are not damped, as each push is a deliberate report of a change (and
+// triggers just one check).
+func resolveAddress(ipv6 bool) (netip.Addr, error) {
+	source := addressSource(ipv6)
+	addr, err := lookupAddress(source, ipv6)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if addr.Is6() != ipv6 {
+		return netip.Addr{}, fmt.Errorf("address %s is of the wrong family", addr)
+	}
+	if viper.GetBool("ddns.validate") && !scopeChosen(source, addr) {
+		allow := make([]netip.Prefix, 0)
+		for _, prefixStr := range viper.GetStringSlice("ddns.allow") {
+			prefix, err := netip.ParsePrefix(prefixStr)
+			if err != nil {
+				return netip.Addr{}, fmt.Errorf("could not parse allowed prefix: %w", err)
+			}
+			allow = append(allow, prefix)
+		}
+		if err := ipaddr.Validate(addr, allow...); err != nil {
+			return netip.Addr{}, fmt.Errorf("address rejected (add its prefix to ddns.allow to publish it anyway): %w", err)
+		}
+	}
+	if source == "dyndns" {
+		return addr, nil
+	}
+	return dampAddress(ipv6, addr), nil
+}
+
+// dampAddress passes a looked up address through the damper of its family,
+// returning the address to use: the one in use until a change has been seen
+// ddns.confirmations times in a row.
+func dampAddress(ipv6 bool, addr netip.Addr) netip.Addr {
+	if _, ok := dampers[ipv6]; !ok {
+		dampers[ipv6] = ipaddr.NewDamper(viper.GetInt("ddns.confirmations"))
+	}
+	damped := dampers[ipv6].Observe(addr)
+	if damped != addr {
+		logrus.WithField("address", addr).WithField("current", damped).Warnln("address change not yet confirmed")
+	}
+	return damped
+}
+
+// scopeChosen reports whether the address was taken from a local interface
+// with a scope other than global that is set in ddns.interface.scopes. Such
+// addresses (ULA and private ones, say of a wireguard network) are published
+// even though they are in a reserved range, as they were explicitly chosen.
+func scopeChosen(source string, addr netip.Addr) bool {
+	if source != "interface" {
+		return false
+	}
+	scope := netif.Address{Addr: addr}.Scope()
+	return scope != netif.ScopeGlobal && slices.Contains(viper.GetStringSlice("ddns.interface.scopes"), string(scope))
+}
+
+// addressSource returns the address source set in the config for the given
+// family. Each family can be given its own source (ddns.source_v4 and
+// ddns.source_v6), otherwise ddns.source is used.
+func addressSource(ipv6 bool) string {
+	source := viper.GetString("ddns.source_v4")
+	if ipv6 {
+		source = viper.GetString("ddns.source_v6")
+	}
+	if source == "" {
+		source = viper.GetString("ddns.source")
+	}
+	return source
+}
+
+// lookupAddress fetches the current public address of the given family from
+// the given address source.
+func lookupAddress(source string, ipv6 bool) (netip.Addr, error) {
+	switch source {
+	case "", "wtfip":
+		ipresp, err := wtfip.LookupIP(ipv6)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return ipresp.Address()
+	case "stun":
+		return stun.LookupIP(viper.GetStringSlice("ddns.stun.servers"), ipv6)
+	case "interface":
+		filters, err := interfaceFilters()
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return netif.LookupIP(viper.GetString("ddns.interface.name"), ipv6, filters...)
+	case "gateway":
+		if ipv6 {
+			return netip.Addr{}, fmt.Errorf("the gateway address source only supports ipv4")
+		}
+		var gw netip.Addr
+		if gwAddress := viper.GetString("ddns.gateway.address"); gwAddress != "" {
+			var err error
+			if gw, err = netip.ParseAddr(gwAddress); err != nil {
+				return netip.Addr{}, fmt.Errorf("could not parse gateway address: %w", err)
+			}
+		}
+		return gateway.LookupIP(gateway.Protocol(viper.GetString("ddns.gateway.protocol")), gw, viper.GetString("ddns.gateway.location"))
+	case "dyndns":
+		if pushServer == nil {
+			return netip.Addr{}, fmt.Errorf("the dyndns address source requires dyndns.listen to be set")
+		}
+		return pushServer.LookupIP(ipv6)
+	default:
+		return netip.Addr{}, fmt.Errorf("unknown address source: %s", source)
+	}
+}
+
+// interfaceFilters builds the address filters for the interface address source
+// from the config.
+func interfaceFilters() ([]netif.AddressFilter, error) {
+	scopes := make([]netif.Scope, 0)
+	for _, scope := range viper.GetStringSlice("ddns.interface.scopes") {
+		switch s := netif.Scope(scope); s {
+		case netif.ScopeGlobal, netif.ScopeULA, netif.ScopePrivate, netif.ScopeShared, netif.ScopeLinkLocal, netif.ScopeLoopback:
+			scopes = append(scopes, s)
+		default:
+			return nil, fmt.Errorf("unknown interface address scope: %s", scope)
+		}
+	}
+	filters := []netif.AddressFilter{netif.AddressFilterScopeIn(scopes...)}
+	switch temporary := viper.GetString("ddns.interface.temporary"); temporary {
+	case "", "stable":
+		filters = append(filters, netif.AddressFilterTemporary(false))
+	case "temporary":
+		filters = append(filters, netif.AddressFilterTemporary(true))
+	case "any":
+	default:
+		return nil, fmt.Errorf("unknown interface temporary address option: %s", temporary)
+	}
+	return filters, nil
+}
+
+type hostSuffixConfig struct {
+	match.RuleConfig `mapstructure:",squash"`
+	Suffix           string `mapstructure:"suffix"`
+}
+
+// hostSuffix is an ipv6 interface identifier to be combined with the delegated
+// prefix for any domain that matches the rule.
+type hostSuffix struct {
+	rule   *match.Rule
+	suffix netip.Addr
+}
+
+// loadHostSuffixes reads the per-host ipv6 suffixes from the config.
+func loadHostSuffixes() ([]hostSuffix, error) {
+	var configs []hostSuffixConfig
+	if err := viper.UnmarshalKey("ddns.suffixes", &configs); err != nil {
+		return nil, fmt.Errorf("could not parse host suffixes: %w", err)
+	}
+	suffixes := make([]hostSuffix, len(configs))
+	for i, config := range configs {
+		rule, err := config.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse host suffix rule: %w", err)
+		}
+		suffix, err := netip.ParseAddr(config.Suffix)
+		if err != nil || !suffix.Is6() {
+			return nil, fmt.Errorf("host suffix %q is not an ipv6 address", config.Suffix)
+		}
+		suffixes[i] = hostSuffix{rule: rule, suffix: suffix}
+	}
+	return suffixes, nil
+}
+
+// domainAddress returns the address that the records of the given domain
+// should have. For ipv6, if a host suffix matches the domain, the address is
+// made from the prefix of the looked up address (of length ddns.prefix_length)
+// and that suffix. Otherwise the looked up address is used as is. The first
+// matching suffix is used.
+func domainAddress(address netip.Addr, subject match.Subject, suffixes []hostSuffix) (netip.Addr, error) {
+	if !address.Is6() {
+		return address, nil
+	}
+	for _, s := range suffixes {
+		if !s.rule.Match(subject) {
+			continue
+		}
+		prefix, err := address.Prefix(viper.GetInt("ddns.prefix_length"))
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("could not get delegated prefix: %w", err)
+		}
+		return ipaddr.WithSuffix(prefix, s.suffix)
+	}
+	return address, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/willfantom/cloudflaere/pkg/ipaddr"
+)
+
+func TestDampAddressFamilies(t *testing.T) {
+	setConfig(t, map[string]any{"ddns.confirmations": 2})
+	previous := dampers
+	dampers = make(map[bool]*ipaddr.Damper)
+	t.Cleanup(func() { dampers = previous })
+
+	v4a, v4b := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+	v6a, v6b := netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2")
+	steps := []struct {
+		ipv6 bool
+		addr netip.Addr
+		want netip.Addr
+	}{
+		{ipv6: false, addr: v4a, want: v4a},
+		{ipv6: true, addr: v6a, want: v6a},
+		{ipv6: false, addr: v4b, want: v4a},
+		// a change of the other family does not count towards this one
+		{ipv6: true, addr: v6b, want: v6a},
+		{ipv6: false, addr: v4b, want: v4b},
+		{ipv6: true, addr: v6b, want: v6b},
+	}
+	for i, step := range steps {
+		if got := dampAddress(step.ipv6, step.addr); got != step.want {
+			t.Fatalf("dampAddress(%v, %s) #%d = %s, want %s", step.ipv6, step.addr, i+1, got, step.want)
+		}
+	}
+}
+
+func TestScopeChosen(t *testing.T) {
+	cgnat := netip.MustParseAddr("100.101.102.103")
+	tests := []struct {
+		name   string
+		source string
+		scopes []string
+		addr   netip.Addr
+		want   bool
+	}{
+		{name: "shared chosen", source: "interface", scopes: []string{"shared"}, addr: cgnat, want: true},
+		{name: "shared not chosen", source: "interface", scopes: []string{"global"}, addr: cgnat},
+		{name: "global never exempt", source: "interface", scopes: []string{"global"}, addr: netip.MustParseAddr("198.51.100.1")},
+		{name: "other source", source: "stun", scopes: []string{"shared"}, addr: cgnat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, map[string]any{"ddns.interface.scopes": tt.scopes})
+			if got := scopeChosen(tt.source, tt.addr); got != tt.want {
+				t.Fatalf("scopeChosen(%s, %s) = %v, want %v", tt.source, tt.addr, got, tt.want)
+			}
+		})
+	}
+	// so addresses of the shared scope are only published when it is chosen
+	if err := ipaddr.Validate(cgnat); err == nil {
+		t.Fatalf("Validate(%s) accepted an address of the shared scope", cgnat)
+	}
+}
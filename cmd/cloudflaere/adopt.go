@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+var (
+	adoptCmd = &cobra.Command{
+		Use:   "adopt",
+		Short: "take over existing A/AAAA records of traefik domains that are not managed by any instance, then exit",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if err := adopt(dryRun); err != nil {
+				logrus.WithError(err).Fatalln("could not adopt records")
+			}
+		},
+	}
+)
+
+func init() {
+	adoptCmd.Flags().Bool("dry-run", false, "only list the records that would be adopted")
+	rootCmd.AddCommand(adoptCmd)
+}
+
+// adopt marks the existing A and AAAA records of every traefik domain as owned
+// by this instance, where they are not managed by any instance already. Only
+// records that the next reconcile would keep are adopted: a lone record of a
+// name and type, where that type is wanted for the domain (as set by the mode,
+// the enabled address families and its policy). Only the ownership of the
+// records is changed, so they are then kept in line with the config by the
+// next reconcile.
+func adopt(dryRun bool) error {
+	c, err := newCloudflare()
+	if err != nil {
+		return err
+	}
+	t, err := tr.NewTraefik(viper.GetString("traefik.url"))
+	if err != nil {
+		return err
+	}
+	trDomains, domainRouters, err := fetchDomains(t)
+	if err != nil {
+		return err
+	}
+	cfZones, err := c.GetZones()
+	if err != nil {
+		return err
+	}
+	domainZones := make(map[string][]string)
+	for _, domain := range trDomains {
+		if _, zoneID, ok := cf.ZoneFor(cfZones, domain.String()); ok {
+			domainZones[zoneID] = append(domainZones[zoneID], domain.String())
+		}
+	}
+	addresses := make(map[string]netip.Addr)
+	if viper.GetString("mode") != "tunnel" {
+		addresses, _ = lookupAddresses()
+	}
+	hostSuffixes, err := loadHostSuffixes()
+	if err != nil {
+		return err
+	}
+	policies, err := loadPolicies()
+	if err != nil {
+		return err
+	}
+	p := &plan{
+		zones:         cfZones,
+		domainZones:   domainZones,
+		domainRouters: domainRouters,
+		addresses:     addresses,
+		hostSuffixes:  hostSuffixes,
+		policies:      policies,
+		instance:      instanceName(),
+		now:           time.Now(),
+	}
+	zoneSpecs, err := p.records()
+	if err != nil {
+		return err
+	}
+
+	adopted := 0
+	for zoneID, specs := range zoneSpecs {
+		records, err := c.GetRecords(zoneID)
+		if err != nil {
+			return err
+		}
+		own, err := newOwnership(c, zoneID, records, currentOwner(), domainRouters)
+		if err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			recs := c.FilterRecords(records, cf.RecordFilterNameIn(spec.Name), cf.RecordFilterTypeIn(spec.Type))
+			record := adoptCandidate(spec, recs, own)
+			if record == nil {
+				continue
+			}
+			if dryRun {
+				logrus.WithField("record", record).Infoln("record would be adopted")
+				adopted++
+				continue
+			}
+			if err := adoptRecord(c, zoneID, record, spec.Comment, own); err != nil {
+				logrus.WithError(err).WithField("record", record).Errorln("could not adopt record")
+				continue
+			}
+			logrus.WithField("record", record).Infoln("record adopted")
+			adopted++
+		}
+	}
+	logrus.WithField("count", adopted).WithField("dry_run", dryRun).Infoln("records adopted")
+	return nil
+}
+
+// adoptRecord takes over an existing record by adding the given comment (the
+// tag of this instance, if ownership is held in the comment) to it, keeping as
+// much of any comment it already had as fits, and claiming it. Nothing else
+// about the record is changed.
+func adoptRecord(c *cf.Cloudflare, zoneID string, record *cf.Record, comment string, own ownership) error {
+	switch {
+	case comment == "":
+		comment = record.Comment
+	case record.Comment != "":
+		comment = cf.JoinComment(record.Comment+" ", comment, "")
+	}
+	updated, err := c.UpdateRecord(zoneID, record.ID, cf.RecordSpec{
+		Type:    record.Type,
+		Name:    record.Name,
+		Content: record.Address,
+		Proxied: record.Proxied,
+		TTL:     record.TTL,
+		Comment: comment,
+	})
+	if err != nil {
+		return err
+	}
+	*record = *updated
+	return own.claim(record)
+}
+
+// adoptable returns the record to adopt in place of creating one for the
+// spec, when the adopt policy is enabled.
+func adoptable(spec cf.RecordSpec, existing []*cf.Record, own ownership) *cf.Record {
+	if !viper.GetBool("adopt") {
+		return nil
+	}
+	return adoptCandidate(spec, existing, own)
+}
+
+// adoptCandidate returns the record that can be adopted for the spec, from the
+// existing records of the same name and type. Only a lone address record that
+// is not managed by any instance is adopted, as with several it is not clear
+// which one should be (and the rest would be deleted as duplicates).
+func adoptCandidate(spec cf.RecordSpec, existing []*cf.Record, own ownership) *cf.Record {
+	if len(existing) != 1 || own.managed(existing[0]) {
+		return nil
+	}
+	if spec.Type != "A" && spec.Type != "AAAA" {
+		return nil
+	}
+	return existing[0]
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/match"
+)
+
+// domainFilter decides which of the domains found on traefik are managed.
+type domainFilter struct {
+	include []*match.Rule
+	exclude []*match.Rule
+}
+
+// loadDomainFilter reads the domain include and exclude rules from the config.
+func loadDomainFilter() (*domainFilter, error) {
+	f := &domainFilter{}
+	var err error
+	if f.include, err = loadRules("domains.include"); err != nil {
+		return nil, fmt.Errorf("could not parse domain include rules: %w", err)
+	}
+	if f.exclude, err = loadRules("domains.exclude"); err != nil {
+		return nil, fmt.Errorf("could not parse domain exclude rules: %w", err)
+	}
+	return f, nil
+}
+
+// allowed reports whether a domain should be managed. When there are include
+// rules, a domain has to match at least one of them. A domain that matches any
+// exclude rule is never managed.
+func (f *domainFilter) allowed(subject match.Subject) bool {
+	if len(f.include) > 0 && !anyRuleMatch(f.include, subject) {
+		return false
+	}
+	return !anyRuleMatch(f.exclude, subject)
+}
+
+func loadRules(key string) ([]*match.Rule, error) {
+	var configs []match.RuleConfig
+	if err := viper.UnmarshalKey(key, &configs); err != nil {
+		return nil, err
+	}
+	rules := make([]*match.Rule, len(configs))
+	for i, config := range configs {
+		rule, err := config.Compile()
+		if err != nil {
+			return nil, err
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+func anyRuleMatch(rules []*match.Rule, subject match.Subject) bool {
+	for _, rule := range rules {
+		if rule.Match(subject) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/health"
+	"github.com/willfantom/cloudflaere/pkg/match"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+// serviceHealth fetches the services from traefik, returning whether each is
+// healthy, keyed by the full service name.
+func serviceHealth(t *tr.Traefik) (map[string]bool, error) {
+	services, err := t.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	health := make(map[string]bool)
+	for _, service := range services {
+		health[service.Name] = service.Healthy()
+	}
+	return health, nil
+}
+
+// domainHealthy reports whether any of the routers of a domain is backed by a
+// healthy service. Services traefik does not report on are taken to be
+// healthy.
+func domainHealthy(routers []tr.TraefikRouter, health map[string]bool) bool {
+	for _, router := range routers {
+		if healthy, ok := health[router.ServiceName()]; !ok || healthy {
+			return true
+		}
+	}
+	return false
+}
+
+// probeTracker holds the health of probed domains between intervals.
+var probeTracker = health.NewTracker()
+
+type probeConfig struct {
+	match.RuleConfig `mapstructure:",squash"`
+	Scheme           string        `mapstructure:"scheme"`
+	Address          string        `mapstructure:"address"`
+	Path             string        `mapstructure:"path"`
+	Status           int           `mapstructure:"status"`
+	Timeout          time.Duration `mapstructure:"timeout"`
+	Insecure         bool          `mapstructure:"insecure"`
+	Rise             int           `mapstructure:"rise"`
+	Fall             int           `mapstructure:"fall"`
+}
+
+// probe is a health check made of any domain that matches the rule.
+type probe struct {
+	rule  *match.Rule
+	probe health.Probe
+	rise  int
+	fall  int
+}
+
+// loadProbes reads the health probes from the config.
+func loadProbes() ([]probe, error) {
+	var configs []probeConfig
+	if err := viper.UnmarshalKey("probes", &configs); err != nil {
+		return nil, fmt.Errorf("could not parse probes: %w", err)
+	}
+	probes := make([]probe, len(configs))
+	for i, config := range configs {
+		rule, err := config.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse probe rule: %w", err)
+		}
+		switch config.Scheme {
+		case "", "http", "https":
+		default:
+			return nil, fmt.Errorf("probe scheme must be http or https, not %s", config.Scheme)
+		}
+		address := config.Address
+		if address == "" {
+			if address = traefikHost(); address == "" {
+				return nil, fmt.Errorf("probe address must be set, as it can not be taken from traefik.url")
+			}
+		}
+		probes[i] = probe{
+			rule: rule,
+			probe: health.Probe{
+				Scheme:   config.Scheme,
+				Address:  address,
+				Path:     config.Path,
+				Status:   config.Status,
+				Timeout:  config.Timeout,
+				Insecure: config.Insecure,
+			},
+			rise: max(config.Rise, 1),
+			fall: max(config.Fall, 1),
+		}
+	}
+	return probes, nil
+}
+
+// traefikHost returns the host of traefik.url, where probes are sent when no
+// address is set. The port of the API is left off, as it is rarely that of the
+// entrypoints apps are served on.
+func traefikHost() string {
+	traefikURL, err := url.Parse(viper.GetString("traefik.url"))
+	if err != nil {
+		return ""
+	}
+	return traefikURL.Hostname()
+}
+
+// probeDomains probes each domain with the first probe that matches it, in
+// parallel, and returns whether each probed domain is healthy. Domains that
+// no probe matches are not included.
+func probeDomains(domains []tr.Domain, domainRouters map[string][]tr.TraefikRouter, probes []probe) map[string]bool {
+	lock := &sync.Mutex{}
+	results := make(map[string]bool)
+	wg := &sync.WaitGroup{}
+	for _, domain := range domains {
+		subject := domainSubject(domain.String(), domainRouters[domain.String()])
+		for _, p := range probes {
+			if !p.rule.Match(subject) {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := p.probe.Check(domain.String())
+				if err != nil {
+					logrus.WithError(err).WithField("domain", domain).Debugln("domain failed health probe")
+				}
+				healthy := probeTracker.Observe(domain.String(), err == nil, p.rise, p.fall)
+				lock.Lock()
+				defer lock.Unlock()
+				results[domain.String()] = healthy
+			}()
+			break
+		}
+	}
+	wg.Wait()
+	probed := make([]string, 0, len(results))
+	for domain := range results {
+		probed = append(probed, domain)
+	}
+	probeTracker.Forget(probed...)
+	return results
+}
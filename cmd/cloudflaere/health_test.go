@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestLoadProbesAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		traefikURL string
+		address    string
+		want       string
+		wantErr    bool
+	}{
+		{name: "set", traefikURL: "http://traefik:8080", address: "10.0.0.2:8443", want: "10.0.0.2:8443"},
+		{name: "traefik host", traefikURL: "http://traefik:8080", want: "traefik"},
+		{name: "traefik ipv6 host", traefikURL: "https://[2001:db8::2]/", want: "2001:db8::2"},
+		{name: "no traefik url", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, map[string]any{
+				"traefik.url": tt.traefikURL,
+				"probes":      []map[string]any{{"provider": "@docker", "address": tt.address}},
+			})
+			probes, err := loadProbes()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("loadProbes() = %v, want an error", probes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadProbes() error = %v", err)
+			}
+			if got := probes[0].probe.Address; got != tt.want {
+				t.Fatalf("probe address = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"maps"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+)
+
+// originName returns the name of the origin this instance adds to pools for
+// the given record type.
+func originName(instance, recordType string) string {
+	if recordType == "AAAA" {
+		return instance + "-v6"
+	}
+	return instance
+}
+
+// renameOrigins moves the origins named after one instance over to another,
+// keeping their addresses. Should the other instance already have an origin
+// of the same family, the one being renamed is dropped instead.
+func renameOrigins(origins map[string]string, from, to string) {
+	for _, recordType := range []string{"A", "AAAA"} {
+		address, ok := origins[originName(from, recordType)]
+		if !ok {
+			continue
+		}
+		delete(origins, originName(from, recordType))
+		if _, ok := origins[originName(to, recordType)]; !ok {
+			origins[originName(to, recordType)] = address
+		}
+	}
+}
+
+// ownOrigins returns the origins a pool should have, given those it has now
+// and the specs of the domain. The origins of the owner are set from the
+// specs, those of its aliases are taken over, and those of other instances
+// are kept. Origins of address families that are unavailable are left as they
+// are.
+func ownOrigins(current map[string]string, o owner, specs []cf.RecordSpec, unavailable map[string]bool) map[string]string {
+	origins := make(map[string]string)
+	maps.Copy(origins, current)
+	for _, alias := range o.aliases {
+		renameOrigins(origins, alias, o.instance)
+	}
+	for _, recordType := range []string{"A", "AAAA"} {
+		if !unavailable[recordType] {
+			delete(origins, originName(o.instance, recordType))
+		}
+	}
+	for _, spec := range specs {
+		origins[originName(o.instance, spec.Type)] = spec.Content
+	}
+	return origins
+}
+
+// syncLoadBalancers publishes every domain with a cloudflare load balancer.
+// Each domain gets a pool (shared by every instance) and a load balancer
+// sending traffic to it, which are made if missing. This instance adds its own
+// addresses to the pool as origins named after it, and removes them once the
+// domain is no longer wanted. Origins named after an alias of this instance
+// are taken over. Pools left with no origins are deleted, along with their
+// load balancers (or kept, if the zone of the load balancer is not managed, as
+// it could not be deleted first). Origins of address families that are unavailable are left
+// as they are.
+func syncLoadBalancers(c *cf.Cloudflare, p *plan, o owner, unavailable map[string]bool) {
+	accountID := viper.GetString("loadbalancer.account")
+	if accountID == "" {
+		logrus.Errorln("loadbalancer.account must be set in loadbalancer mode")
+		return
+	}
+	pools, err := c.GetPools(accountID)
+	if err != nil {
+		logrus.WithError(err).Errorln("could not fetch load balancing pools from cloudflare")
+		return
+	}
+	poolsByHost := make(map[string]*cf.Pool)
+	for _, pool := range pools {
+		poolsByHost[pool.Hostname] = pool
+	}
+	lbs := make(map[string][]*cf.LoadBalancer)
+	zoneLoadBalancers := func(zoneID string) ([]*cf.LoadBalancer, error) {
+		if _, ok := lbs[zoneID]; !ok {
+			zoneLBs, err := c.GetLoadBalancers(zoneID)
+			if err != nil {
+				return nil, err
+			}
+			lbs[zoneID] = zoneLBs
+		}
+		return lbs[zoneID], nil
+	}
+	summary := &report{}
+
+	// ADD & UPDATE
+	wanted := make(map[string]bool)
+	for zoneID, domains := range p.domainZones {
+		for _, domain := range domains {
+			specs := p.addressSpecs(domain)
+			if len(specs) == 0 {
+				continue
+			}
+			wanted[domain] = true
+			pool := poolsByHost[domain]
+			if pool == nil {
+				origins := make(map[string]string)
+				for _, spec := range specs {
+					origins[originName(o.instance, spec.Type)] = spec.Content
+				}
+				if pool, err = c.CreatePool(accountID, domain, origins, viper.GetString("loadbalancer.monitor")); err != nil {
+					logrus.WithError(err).WithField("domain", domain).Errorln("could not create load balancing pool")
+					summary.failed++
+					continue
+				}
+				logrus.WithField("domain", domain).WithField("origins", origins).Debugln("load balancing pool created")
+				summary.created++
+			} else {
+				syncPool(c, accountID, pool, ownOrigins(pool.Origins, o, specs, unavailable), summary)
+			}
+
+			zoneLBs, err := zoneLoadBalancers(zoneID)
+			if err != nil {
+				logrus.WithError(err).WithField("zone_id", zoneID).Errorln("could not fetch load balancers from cloudflare")
+				summary.failed++
+				continue
+			}
+			var lb *cf.LoadBalancer
+			for _, zoneLB := range zoneLBs {
+				if cf.SameName(zoneLB.Name, domain) {
+					lb = zoneLB
+				}
+			}
+			switch {
+			case lb == nil:
+				rp := resolvePolicy(domainSubject(domain, p.domainRouters[domain]), p.policies)
+				if lb, err = c.CreateLoadBalancer(zoneID, domain, pool.ID, rp.proxied, rp.ttl); err != nil {
+					logrus.WithError(err).WithField("domain", domain).Errorln("could not create load balancer")
+					summary.failed++
+					continue
+				}
+				lbs[zoneID] = append(lbs[zoneID], lb)
+				logrus.WithField("domain", domain).Debugln("load balancer created")
+			case !lb.Managed:
+				logrus.WithField("domain", domain).Debugln("load balancer is not managed by cloudflaere")
+			}
+		}
+	}
+
+	// CLEAN
+	for _, pool := range pools {
+		if wanted[pool.Hostname] {
+			continue
+		}
+		origins := ownOrigins(pool.Origins, o, nil, unavailable)
+		if len(origins) > 0 {
+			syncPool(c, accountID, pool, origins, summary)
+			continue
+		}
+		// Pool has no origins left -> delete it and its load balancer
+		_, zoneID, ok := cf.ZoneFor(p.zones, pool.Hostname)
+		if !ok {
+			// the load balancer can not be deleted first, and would be left
+			// sending traffic to a pool that no longer exists
+			logrus.WithField("domain", pool.Hostname).Warnln("not deleting load balancing pool, as the zone of its load balancer is not managed")
+			summary.unchanged++
+			continue
+		}
+		zoneLBs, err := zoneLoadBalancers(zoneID)
+		if err != nil {
+			logrus.WithError(err).WithField("zone_id", zoneID).Errorln("could not fetch load balancers from cloudflare")
+			summary.failed++
+			continue
+		}
+		lbDeleted := true
+		for _, lb := range zoneLBs {
+			if !lb.Managed || !cf.SameName(lb.Name, pool.Hostname) {
+				continue
+			}
+			if err := c.DeleteLoadBalancer(zoneID, lb.ID); err != nil {
+				logrus.WithError(err).WithField("domain", pool.Hostname).Errorln("could not delete load balancer")
+				summary.failed++
+				lbDeleted = false
+			} else {
+				logrus.WithField("domain", pool.Hostname).Debugln("load balancer deleted")
+			}
+		}
+		if !lbDeleted {
+			continue
+		}
+		if err := c.DeletePool(accountID, pool.ID); err != nil {
+			logrus.WithError(err).WithField("domain", pool.Hostname).Errorln("could not delete load balancing pool")
+			summary.failed++
+		} else {
+			logrus.WithField("domain", pool.Hostname).Debugln("load balancing pool deleted")
+			summary.deleted++
+		}
+	}
+	logrus.WithFields(summary.fields()).Infoln("load balancing pools reconciled")
+}
+
+// syncPool sets the origins of the pool, if they differ from those it has.
+func syncPool(c *cf.Cloudflare, accountID string, pool *cf.Pool, origins map[string]string, summary *report) {
+	if maps.Equal(pool.Origins, origins) {
+		summary.unchanged++
+		return
+	}
+	pool.Origins = origins
+	if err := c.UpdatePoolOrigins(accountID, pool); err != nil {
+		logrus.WithError(err).WithField("domain", pool.Hostname).Errorln("could not update load balancing pool")
+		summary.failed++
+		return
+	}
+	logrus.WithField("domain", pool.Hostname).WithField("origins", origins).Debugln("load balancing pool updated")
+	summary.updated++
+}
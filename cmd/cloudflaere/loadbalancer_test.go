@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+func TestOwnOrigins(t *testing.T) {
+	specs := []cf.RecordSpec{{Type: "A", Content: "198.51.100.1"}}
+	tests := []struct {
+		name        string
+		current     map[string]string
+		owner       owner
+		specs       []cf.RecordSpec
+		unavailable map[string]bool
+		want        map[string]string
+	}{
+		{
+			name:    "set from specs, keeping other instances",
+			current: map[string]string{"host1": "198.51.100.9", "host2": "203.0.113.5"},
+			owner:   owner{instance: "host1"},
+			specs:   specs,
+			want:    map[string]string{"host1": "198.51.100.1", "host2": "203.0.113.5"},
+		},
+		{
+			name:    "removed without specs",
+			current: map[string]string{"host1": "198.51.100.9", "host1-v6": "2001:db8::9", "host2": "203.0.113.5"},
+			owner:   owner{instance: "host1"},
+			want:    map[string]string{"host2": "203.0.113.5"},
+		},
+		{
+			name:        "unavailable family kept",
+			current:     map[string]string{"host1": "198.51.100.9", "host1-v6": "2001:db8::9"},
+			owner:       owner{instance: "host1"},
+			specs:       specs,
+			unavailable: map[string]bool{"AAAA": true},
+			want:        map[string]string{"host1": "198.51.100.1", "host1-v6": "2001:db8::9"},
+		},
+		{
+			name:    "alias origins taken over",
+			current: map[string]string{"old": "198.51.100.9", "old-v6": "2001:db8::9", "host2": "203.0.113.5"},
+			owner:   owner{instance: "host1", aliases: []string{"old"}},
+			specs:   specs,
+			want:    map[string]string{"host1": "198.51.100.1", "host2": "203.0.113.5"},
+		},
+		{
+			name:        "alias origin of unavailable family renamed",
+			current:     map[string]string{"old": "198.51.100.9", "old-v6": "2001:db8::9"},
+			owner:       owner{instance: "host1", aliases: []string{"old"}},
+			specs:       specs,
+			unavailable: map[string]bool{"AAAA": true},
+			want:        map[string]string{"host1": "198.51.100.1", "host1-v6": "2001:db8::9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := maps.Clone(tt.current)
+			got := ownOrigins(tt.current, tt.owner, tt.specs, tt.unavailable)
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("ownOrigins() = %v, want %v", got, tt.want)
+			}
+			if !maps.Equal(tt.current, current) {
+				t.Fatalf("ownOrigins() changed the current origins to %v", tt.current)
+			}
+		})
+	}
+}
+
+func TestRenameOrigins(t *testing.T) {
+	origins := map[string]string{"old": "198.51.100.9", "old-v6": "2001:db8::9", "new-v6": "2001:db8::1"}
+	renameOrigins(origins, "old", "new")
+	want := map[string]string{"new": "198.51.100.9", "new-v6": "2001:db8::1"}
+	if !maps.Equal(origins, want) {
+		t.Fatalf("renameOrigins() = %v, want %v", origins, want)
+	}
+}
+
+// lbAPI is a fake of the load balancing endpoints of the cloudflare API, for a
+// single account and zone.
+type lbAPI struct {
+	t     *testing.T
+	lock  sync.Mutex
+	next  int
+	pools map[string]cloudflare.LoadBalancerPool
+	lbs   map[string]cloudflare.LoadBalancer
+}
+
+func (f *lbAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var result any
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) >= 4 && path[0] == "accounts" && path[1] == "acc" && path[3] == "pools":
+		switch {
+		case len(path) == 4 && r.Method == http.MethodGet:
+			pools := make([]cloudflare.LoadBalancerPool, 0)
+			for _, pool := range f.pools {
+				pools = append(pools, pool)
+			}
+			result = pools
+		case len(path) == 4 && r.Method == http.MethodPost:
+			var pool cloudflare.LoadBalancerPool
+			json.NewDecoder(r.Body).Decode(&pool)
+			f.next++
+			pool.ID = fmt.Sprintf("pool%d", f.next)
+			f.pools[pool.ID] = pool
+			result = pool
+		case len(path) == 5 && r.Method == http.MethodGet:
+			result = f.pools[path[4]]
+		case len(path) == 5 && r.Method == http.MethodPut:
+			var pool cloudflare.LoadBalancerPool
+			json.NewDecoder(r.Body).Decode(&pool)
+			f.pools[path[4]] = pool
+			result = pool
+		case len(path) == 5 && r.Method == http.MethodDelete:
+			delete(f.pools, path[4])
+			result = map[string]string{"id": path[4]}
+		}
+	case len(path) >= 3 && path[0] == "zones" && path[1] == "z1" && path[2] == "load_balancers":
+		switch {
+		case len(path) == 3 && r.Method == http.MethodGet:
+			lbs := make([]cloudflare.LoadBalancer, 0)
+			for _, lb := range f.lbs {
+				lbs = append(lbs, lb)
+			}
+			result = lbs
+		case len(path) == 3 && r.Method == http.MethodPost:
+			var lb cloudflare.LoadBalancer
+			json.NewDecoder(r.Body).Decode(&lb)
+			f.next++
+			lb.ID = fmt.Sprintf("lb%d", f.next)
+			f.lbs[lb.ID] = lb
+			result = lb
+		case len(path) == 4 && r.Method == http.MethodDelete:
+			delete(f.lbs, path[3])
+			result = map[string]string{"id": path[3]}
+		}
+	}
+	if result == nil {
+		f.t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"success":     true,
+		"errors":      []any{},
+		"messages":    []any{},
+		"result":      result,
+		"result_info": map[string]int{"page": 1, "per_page": 100, "total_pages": 1, "count": 1, "total_count": 1},
+	})
+}
+
+// addPool adds a pool made by cloudflaere for the hostname, with the given
+// origins.
+func (f *lbAPI) addPool(hostname string, origins map[string]string) {
+	f.next++
+	pool := cloudflare.LoadBalancerPool{
+		ID:          fmt.Sprintf("pool%d", f.next),
+		Name:        cf.PoolName(hostname),
+		Description: cf.LoadBalancerMarker + " " + hostname,
+	}
+	for name, address := range origins {
+		pool.Origins = append(pool.Origins, cloudflare.LoadBalancerOrigin{Name: name, Address: address, Enabled: true, Weight: 1})
+	}
+	f.pools[pool.ID] = pool
+}
+
+// addLoadBalancer adds a load balancer for the hostname, sending traffic to
+// the pool made for it.
+func (f *lbAPI) addLoadBalancer(hostname, description string) {
+	f.next++
+	lb := cloudflare.LoadBalancer{ID: fmt.Sprintf("lb%d", f.next), Name: hostname, Description: description}
+	if pool, ok := f.pool(hostname); ok {
+		lb.DefaultPools = []string{pool.ID}
+	}
+	f.lbs[lb.ID] = lb
+}
+
+// pool returns the pool made for the hostname.
+func (f *lbAPI) pool(hostname string) (cloudflare.LoadBalancerPool, bool) {
+	for _, pool := range f.pools {
+		if pool.Name == cf.PoolName(hostname) {
+			return pool, true
+		}
+	}
+	return cloudflare.LoadBalancerPool{}, false
+}
+
+// origins returns the origins of the pool made for the hostname.
+func (f *lbAPI) origins(hostname string) map[string]string {
+	pool, ok := f.pool(hostname)
+	if !ok {
+		return nil
+	}
+	origins := make(map[string]string)
+	for _, origin := range pool.Origins {
+		origins[origin.Name] = origin.Address
+	}
+	return origins
+}
+
+// loadBalancer returns the load balancer for the hostname.
+func (f *lbAPI) loadBalancer(hostname string) (cloudflare.LoadBalancer, bool) {
+	for _, lb := range f.lbs {
+		if lb.Name == hostname {
+			return lb, true
+		}
+	}
+	return cloudflare.LoadBalancer{}, false
+}
+
+// newLBAPI starts a fake load balancing API and points the cloudflare client
+// at it for the duration of the test.
+func newLBAPI(t *testing.T) (*lbAPI, *cf.Cloudflare) {
+	t.Helper()
+	f := &lbAPI{t: t, pools: make(map[string]cloudflare.LoadBalancerPool), lbs: make(map[string]cloudflare.LoadBalancer)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	setConfig(t, map[string]any{
+		"cloudflare.api_url":   server.URL,
+		"cloudflare.zone":      "token",
+		"cloudflare.dns":       "token",
+		"loadbalancer.account": "acc",
+	})
+	c, err := newCloudflare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, c
+}
+
+// lbPlan returns a plan publishing the domains in zone z1 (example.com) at the
+// given addresses.
+func lbPlan(addresses map[string]netip.Addr, domains ...string) *plan {
+	return &plan{
+		zones:         map[string]string{"example.com": "z1"},
+		domainZones:   map[string][]string{"z1": domains},
+		domainRouters: make(map[string][]tr.TraefikRouter),
+		addresses:     addresses,
+		instance:      "host1",
+		now:           time.Now(),
+	}
+}
+
+func TestSyncLoadBalancers(t *testing.T) {
+	v4, v6 := netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("2001:db8::1")
+	host1 := owner{instance: "host1"}
+
+	t.Run("pool and load balancer created", func(t *testing.T) {
+		f, c := newLBAPI(t)
+		syncLoadBalancers(c, lbPlan(map[string]netip.Addr{"A": v4, "AAAA": v6}, "a.example.com"), host1, nil)
+		want := map[string]string{"host1": v4.String(), "host1-v6": v6.String()}
+		if got := f.origins("a.example.com"); !maps.Equal(got, want) {
+			t.Fatalf("pool origins = %v, want %v", got, want)
+		}
+		pool, _ := f.pool("a.example.com")
+		lb, ok := f.loadBalancer("a.example.com")
+		if !ok {
+			t.Fatal("no load balancer created")
+		}
+		if !slices.Equal(lb.DefaultPools, []string{pool.ID}) || lb.FallbackPool != pool.ID {
+			t.Fatalf("load balancer pools = %v (fallback %s), want %s", lb.DefaultPools, lb.FallbackPool, pool.ID)
+		}
+		if !strings.Contains(lb.Description, cf.LoadBalancerMarker) {
+			t.Fatalf("load balancer description %q has no marker", lb.Description)
+		}
+
+		// nothing changes on a second sync
+		syncLoadBalancers(c, lbPlan(map[string]netip.Addr{"A": v4, "AAAA": v6}, "a.example.com"), host1, nil)
+		if len(f.pools) != 1 || len(f.lbs) != 1 {
+			t.Fatalf("second sync left %d pools and %d load balancers, want 1 of each", len(f.pools), len(f.lbs))
+		}
+	})
+
+	t.Run("other instances kept", func(t *testing.T) {
+		f, c := newLBAPI(t)
+		f.addPool("a.example.com", map[string]string{"host1": "198.51.100.9", "host2": "203.0.113.5"})
+		f.addLoadBalancer("a.example.com", cf.LoadBalancerMarker)
+		syncLoadBalancers(c, lbPlan(map[string]netip.Addr{"A": v4}, "a.example.com"), host1, nil)
+		want := map[string]string{"host1": v4.String(), "host2": "203.0.113.5"}
+		if got := f.origins("a.example.com"); !maps.Equal(got, want) {
+			t.Fatalf("pool origins = %v, want %v", got, want)
+		}
+		if len(f.lbs) != 1 {
+			t.Fatalf("%d load balancers, want 1", len(f.lbs))
+		}
+	})
+
+	t.Run("unavailable family left alone", func(t *testing.T) {
+		f, c := newLBAPI(t)
+		f.addPool("a.example.com", map[string]string{"host1": "198.51.100.9", "host1-v6": "2001:db8::9"})
+		f.addPool("b.example.com", map[string]string{"host1": "198.51.100.9", "host1-v6": "2001:db8::9"})
+		f.addLoadBalancer("a.example.com", cf.LoadBalancerMarker)
+		f.addLoadBalancer("b.example.com", cf.LoadBalancerMarker)
+		syncLoadBalancers(c, lbPlan(map[string]netip.Addr{"A": v4}, "a.example.com"), host1, map[string]bool{"AAAA": true})
+		want := map[string]string{"host1": v4.String(), "host1-v6": "2001:db8::9"}
+		if got := f.origins("a.example.com"); !maps.Equal(got, want) {
+			t.Fatalf("pool origins = %v, want %v", got, want)
+		}
+		// the pool of a domain no longer wanted keeps the unavailable origin
+		want = map[string]string{"host1-v6": "2001:db8::9"}
+		if got := f.origins("b.example.com"); !maps.Equal(got, want) {
+			t.Fatalf("unwanted pool origins = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("empty pools deleted", func(t *testing.T) {
+		f, c := newLBAPI(t)
+		f.addPool("gone.example.com", map[string]string{"host1": "198.51.100.9"})
+		f.addLoadBalancer("gone.example.com", cf.LoadBalancerMarker)
+		f.addPool("shared.example.com", map[string]string{"host1": "198.51.100.9", "host2": "203.0.113.5"})
+		f.addPool("hand.example.com", map[string]string{"host1": "198.51.100.9"})
+		f.addLoadBalancer("hand.example.com", "made by hand")
+		syncLoadBalancers(c, lbPlan(map[string]netip.Addr{"A": v4}), host1, nil)
+		if _, ok := f.pool("gone.example.com"); ok {
+			t.Fatal("empty pool not deleted")
+		}
+		if _, ok := f.loadBalancer("gone.example.com"); ok {
+			t.Fatal("load balancer of empty pool not deleted")
+		}
+		want := map[string]string{"host2": "203.0.113.5"}
+		if got := f.origins("shared.example.com"); !maps.Equal(got, want) {
+			t.Fatalf("shared pool origins = %v, want %v", got, want)
+		}
+		if _, ok := f.loadBalancer("hand.example.com"); !ok {
+			t.Fatal("load balancer not made by cloudflaere was deleted")
+		}
+	})
+
+	t.Run("pool kept when zone unknown", func(t *testing.T) {
+		f, c := newLBAPI(t)
+		// the load balancer of a pool in a zone that is not managed can not
+		// be deleted, so neither is the pool
+		f.addPool("a.other.org", map[string]string{"host1": "198.51.100.9"})
+		syncLoadBalancers(c, lbPlan(map[string]netip.Addr{"A": v4}), host1, nil)
+		want := map[string]string{"host1": "198.51.100.9"}
+		if got := f.origins("a.other.org"); !maps.Equal(got, want) {
+			t.Fatalf("pool origins = %v, want %v", got, want)
+		}
+	})
+}
@@ -1,18 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net/netip"
-	"os"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/willfantom/cloudflaere/pkg/cf"
-	"github.com/willfantom/cloudflaere/pkg/tr"
-	"github.com/willfantom/cloudflaere/pkg/wtfip"
+	"github.com/willfantom/cloudflaere/pkg/dyndns"
+	"github.com/willfantom/cloudflaere/pkg/netif"
+	"github.com/willfantom/cloudflaere/pkg/stun"
 )
 
 var (
@@ -38,6 +38,34 @@ var (
 
 		},
 		Run: func(cmd *cobra.Command, args []string) {
+			// WATCH FOR LOCAL ADDRESS CHANGES
+			var addressChanges <-chan struct{}
+			if iface := viper.GetString("ddns.watch"); iface != "" {
+				changes, err := netif.Watch(context.Background(), iface)
+				if err != nil {
+					logrus.WithError(err).WithField("interface", iface).Warnln("could not watch interface for address changes")
+				} else {
+					logrus.WithField("interface", iface).Infoln("watching interface for address changes")
+					addressChanges = changes
+				}
+			}
+
+			// LISTEN FOR PUSHED ADDRESSES
+			var addressPushes <-chan struct{}
+			if listen := viper.GetString("dyndns.listen"); listen != "" {
+				if viper.GetString("dyndns.username") == "" || viper.GetString("dyndns.password") == "" {
+					logrus.Fatalln("dyndns username and password must be set to run the dyndns server")
+				}
+				pushServer = dyndns.NewServer(viper.GetString("dyndns.username"), viper.GetString("dyndns.password"), viper.GetStringSlice("dyndns.hostnames")...)
+				addressPushes = pushServer.Updates()
+				go func() {
+					logrus.WithField("listen", listen).Infoln("starting dyndns server")
+					if err := http.ListenAndServe(listen, pushServer); err != nil {
+						logrus.WithError(err).Fatalln("dyndns server failed")
+					}
+				}()
+			}
+
 			firstRun := true
 			for {
 
@@ -47,163 +75,16 @@ var (
 					firstRun = false
 				} else {
 					logrus.WithField("interval", viper.GetDuration("interval")).Infoln("waiting for next interval")
-					<-time.After(viper.GetDuration("interval"))
-				}
-
-				// CONFIGURE CLIENTS
-				c, err := cf.NewCloudflare(viper.GetString("cloudflare.zone"), viper.GetString("cloudflare.dns"))
-				if err != nil {
-					logrus.WithError(err).Errorln("cloudflare api client could not be created")
-					continue
-				}
-				t, err := tr.NewTraefik(viper.GetString("traefik.url"))
-				if err != nil {
-					logrus.WithError(err).Errorln("traefik api client could not be created")
-					continue
-				}
-
-				// GET DOMAINS FROM TRAEFIK
-				trDomains, err := t.GetDomains()
-				if err != nil {
-					logrus.WithError(err).Errorln("could not fetch domains from traefik")
-					continue
-				}
-				logrus.WithField("count", len(trDomains)).Infoln("domains fetched from traefik")
-				if len(trDomains) == 0 {
-					logrus.Warnln("no domains found in traefik")
-					continue
-				}
-
-				// GET ZONES FROM CLOUDFLARE
-				cfZones, err := c.GetZones()
-				if err != nil {
-					logrus.WithError(err).Errorln("could not fetch zones from cloudflare")
-					continue
-				}
-				logrus.WithField("count", len(cfZones)).Infoln("zones fetched from cloudflare")
-				if len(cfZones) == 0 {
-					logrus.Warnln("no zones found in cloudflare")
-					continue
-				}
-
-				// DOMAIN ZONE BUCKETS
-				domainZones := make(map[string][]string)
-				for _, domain := range trDomains {
-					logrus.WithField("domain", domain).Debugln("processing domain")
-					rootDomain, err := domain.Root()
-					if err != nil {
-						logrus.WithError(err).WithField("domain", domain).Warnln("could not parse root domain")
-						continue
-					}
-					if _, ok := cfZones[rootDomain]; !ok {
-						logrus.WithField("domain", domain).Warnln("root domain is not in cloudflare zones")
-						continue
-					}
-					logrus.WithField("root_domain", rootDomain).Debugln("root domain parsed")
-					if _, ok := domainZones[cfZones[rootDomain]]; !ok {
-						domainZones[cfZones[rootDomain]] = make([]string, 0)
-					}
-					domainZones[cfZones[rootDomain]] = append(domainZones[cfZones[rootDomain]], domain.String())
-				}
-
-				// CREATE MAGIC COMMENT
-				magicCommentKey := viper.GetString("instance")
-				if magicCommentKey == "" {
-					magicCommentKey, _ = os.Hostname()
-				}
-				magicComment := fmt.Sprintf("##cloudflaere:%s##", magicCommentKey)
-
-				// GET ADDRESSES
-				addresses := make(map[string]netip.Addr)
-				if viper.GetBool("ddns.ipv4") {
-					ipresp, err := wtfip.LookupIP(false)
-					if err != nil {
-						logrus.WithError(err).Errorln("could not fetch ipv4 address")
-						continue
+					select {
+					case <-time.After(viper.GetDuration("interval")):
+					case <-addressChanges:
+						logrus.WithField("interface", viper.GetString("ddns.watch")).Infoln("interface address changed")
+					case <-addressPushes:
+						logrus.Infoln("address pushed to dyndns server")
 					}
-					ip, err := ipresp.Address()
-					if err != nil {
-						logrus.WithError(err).WithField("address", ipresp.IPAddress).Errorln("could not parse ipv4 address")
-						continue
-					}
-					addresses["A"] = ip
-					logrus.WithField("address", ip.StringExpanded()).Infoln("address v4 fetched")
 				}
-				if viper.GetBool("ddns.ipv6") {
-					ipresp, err := wtfip.LookupIP(true)
-					if err != nil {
-						logrus.WithError(err).Errorln("could not fetch ipv6 address")
-						continue
-					}
-					ip, err := ipresp.Address()
-					if err != nil {
-						logrus.WithError(err).WithField("address", ipresp.IPAddress).Errorln("could not parse ipv6 address")
-						continue
-					}
-					addresses["AAAA"] = ip
-					logrus.WithField("address", ip.StringExpanded()).Infoln("address v6 fetched")
-				}
-
-				// FOR EACH ROOT DOMAIN
-				for zoneID, domains := range domainZones {
-					records, err := c.GetRecords(zoneID)
-					if err != nil {
-						logrus.WithError(err).WithField("zone_id", zoneID).WithField("domains", len(domains)).Errorln("could not fetch records from cloudflare")
-						continue
-					}
-					logrus.WithField("zone_id", zoneID).WithField("records", len(records)).Debugln("records fetched from cloudflare")
-
-					// ADD
-					for recordType, address := range addresses {
-						for _, domain := range domains {
-							recs := c.FilterRecords(records, cf.RecordFilterNameIn(domain), cf.RecordFilterTypeIn(recordType))
-							if len(recs) == 0 {
-								// Record not exist -> create
-								if r, err := c.AddRecord(zoneID, recordType, domain, address.StringExpanded(), magicComment, viper.GetBool("cloudflare.proxied")); err != nil {
-									logrus.WithError(err).WithField("domain", domain).Errorln("could not add record")
-								} else {
-									logrus.WithField("record", r).WithField("domain", domain).Debugln("record created")
-								}
-								continue
-							}
-							if len(recs) > 1 {
-								logrus.WithField("domain", domain).Errorln("more than one record found for domain")
-								continue
-							}
-							if recs[0].Address != address.StringExpanded() && strings.Contains(recs[0].Comment, magicComment) {
-								// Record exists but address is different -> update
-								if err := c.UpdateRecordAddress(zoneID, recs[0].ID, address.StringExpanded()); err != nil {
-									logrus.WithError(err).WithField("domain", domain).Errorln("could not update record")
-								} else {
-									logrus.WithField("domain", domain).Debugln("record updated")
-								}
-							} else {
-								logrus.WithField("domain", domain).Debugln("record is up to date")
-							}
-						}
-					}
 
-					// CLEAN
-					for _, record := range records {
-						if strings.Contains(record.Comment, magicComment) {
-							hasDomain := false
-							for _, domain := range domains {
-								if strings.EqualFold(record.Name, domain) {
-									hasDomain = true
-									break
-								}
-							}
-							if !hasDomain {
-								// Record exists but domain is not in traefik -> delete
-								if err := c.DeleteRecord(zoneID, record.ID); err != nil {
-									logrus.WithError(err).WithField("record", record).Errorln("could not delete record")
-								} else {
-									logrus.WithField("record", record).Debugln("record deleted")
-								}
-							}
-						}
-					}
-				}
+				reconcile()
 			}
 		},
 	}
@@ -230,26 +111,110 @@ func init() {
 	viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
 	rootCmd.PersistentFlags().Duration("interval", time.Minute, "interval between checks")
 	viper.BindPFlag("interval", rootCmd.PersistentFlags().Lookup("interval"))
+	rootCmd.PersistentFlags().Duration("sweep", time.Hour, "time between visits of every zone, to clean up records in zones without domains")
+	viper.BindPFlag("sweep", rootCmd.PersistentFlags().Lookup("sweep"))
 	rootCmd.PersistentFlags().String("instance", "", "unique name of cloudflaere instance (default $HOSTNAME)")
 	viper.BindPFlag("instance", rootCmd.PersistentFlags().Lookup("instance"))
+	rootCmd.PersistentFlags().StringSlice("instance-aliases", nil, "previous names of this instance, whose records are taken over")
+	viper.BindPFlag("instance_aliases", rootCmd.PersistentFlags().Lookup("instance-aliases"))
+	rootCmd.PersistentFlags().String("mode", "address", "how domains are published (address, cname, tunnel, loadbalancer)")
+	viper.BindPFlag("mode", rootCmd.PersistentFlags().Lookup("mode"))
+	rootCmd.PersistentFlags().String("cname-host", "", "hostname given the address records that all domains point to in cname mode")
+	viper.BindPFlag("cname.host", rootCmd.PersistentFlags().Lookup("cname-host"))
+	rootCmd.PersistentFlags().String("registry", "comment", "how ownership of records is held (comment, txt)")
+	viper.BindPFlag("registry.type", rootCmd.PersistentFlags().Lookup("registry"))
+	rootCmd.PersistentFlags().Bool("registry-migrate", false, "also take records with the magic comment as owned, giving them txt registry entries")
+	viper.BindPFlag("registry.migrate", rootCmd.PersistentFlags().Lookup("registry-migrate"))
+	rootCmd.PersistentFlags().Bool("adopt", false, "take over existing records of traefik domains that are not managed by any instance")
+	viper.BindPFlag("adopt", rootCmd.PersistentFlags().Lookup("adopt"))
 
 	// traefik
 	rootCmd.PersistentFlags().String("tr-url", "", "target traefik url (e.g. https://traefik.example.com)")
 	viper.BindPFlag("traefik.url", rootCmd.PersistentFlags().Lookup("tr-url"))
+	rootCmd.PersistentFlags().Bool("tr-health", false, "withdraw records of domains whose traefik services have all servers down")
+	viper.BindPFlag("traefik.health", rootCmd.PersistentFlags().Lookup("tr-health"))
 
 	// cloudflare
-	rootCmd.PersistentFlags().Bool("cf-proxied", false, "set new records to be proxied by cloudflare")
+	rootCmd.PersistentFlags().Bool("cf-proxied", false, "set managed records to be proxied by cloudflare")
 	viper.BindPFlag("cloudflare.proxied", rootCmd.PersistentFlags().Lookup("cf-proxied"))
+	rootCmd.PersistentFlags().Int("cf-ttl", 1, "ttl of managed records in seconds (1 is automatic)")
+	viper.BindPFlag("cloudflare.ttl", rootCmd.PersistentFlags().Lookup("cf-ttl"))
 	rootCmd.PersistentFlags().String("cf-zone", "", "cloudflare zone read api key")
 	viper.BindPFlag("cloudflare.zone", rootCmd.PersistentFlags().Lookup("cf-zone"))
 	rootCmd.PersistentFlags().String("cf-dns", "", "cloudflare dns edit api key")
 	viper.BindPFlag("cloudflare.dns", rootCmd.PersistentFlags().Lookup("cf-dns"))
+	rootCmd.PersistentFlags().StringSlice("cf-zones-allow", []string{}, "names of the only cloudflare zones to manage (default all)")
+	viper.BindPFlag("cloudflare.zones.allow", rootCmd.PersistentFlags().Lookup("cf-zones-allow"))
+	rootCmd.PersistentFlags().StringSlice("cf-zones-ids", []string{}, "ids of the only cloudflare zones to manage (default all)")
+	viper.BindPFlag("cloudflare.zones.ids", rootCmd.PersistentFlags().Lookup("cf-zones-ids"))
+	rootCmd.PersistentFlags().StringSlice("cf-zones-deny", []string{}, "names of cloudflare zones to never manage")
+	viper.BindPFlag("cloudflare.zones.deny", rootCmd.PersistentFlags().Lookup("cf-zones-deny"))
+	rootCmd.PersistentFlags().StringSlice("cf-accounts", []string{}, "ids of the only cloudflare accounts whose zones are managed (default all)")
+	viper.BindPFlag("cloudflare.accounts", rootCmd.PersistentFlags().Lookup("cf-accounts"))
+	rootCmd.PersistentFlags().String("cf-api-url", "", "base url of the cloudflare api (default https://api.cloudflare.com/client/v4)")
+	viper.BindPFlag("cloudflare.api_url", rootCmd.PersistentFlags().Lookup("cf-api-url"))
+
+	// loadbalancer
+	rootCmd.PersistentFlags().String("lb-account", "", "id of the cloudflare account that load balancing pools are made in")
+	viper.BindPFlag("loadbalancer.account", rootCmd.PersistentFlags().Lookup("lb-account"))
+	rootCmd.PersistentFlags().String("lb-monitor", "", "id of the monitor used to health check the origins of new pools")
+	viper.BindPFlag("loadbalancer.monitor", rootCmd.PersistentFlags().Lookup("lb-monitor"))
+
+	// tunnel
+	rootCmd.PersistentFlags().String("tunnel-id", "", "id of the cloudflare tunnel that domains point to in tunnel mode")
+	viper.BindPFlag("tunnel.id", rootCmd.PersistentFlags().Lookup("tunnel-id"))
+	rootCmd.PersistentFlags().Bool("tunnel-ingress", false, "keep the remote ingress config of the tunnel in sync with the domains found on traefik")
+	viper.BindPFlag("tunnel.ingress", rootCmd.PersistentFlags().Lookup("tunnel-ingress"))
+	rootCmd.PersistentFlags().String("tunnel-account", "", "id of the cloudflare account that the tunnel belongs to")
+	viper.BindPFlag("tunnel.account", rootCmd.PersistentFlags().Lookup("tunnel-account"))
+	rootCmd.PersistentFlags().String("tunnel-service", "", "url of traefik as reached by cloudflared (e.g. http://localhost:80)")
+	viper.BindPFlag("tunnel.service", rootCmd.PersistentFlags().Lookup("tunnel-service"))
 
 	// ddns
 	rootCmd.PersistentFlags().BoolP("ipv4", "4", false, "enable ipv4 ddns")
 	viper.BindPFlag("ddns.ipv4", rootCmd.PersistentFlags().Lookup("ipv4"))
 	rootCmd.PersistentFlags().BoolP("ipv6", "6", false, "enable ipv6 ddns")
 	viper.BindPFlag("ddns.ipv6", rootCmd.PersistentFlags().Lookup("ipv6"))
+	rootCmd.PersistentFlags().String("source", "wtfip", "address source to use (wtfip, stun, interface, gateway, dyndns)")
+	viper.BindPFlag("ddns.source", rootCmd.PersistentFlags().Lookup("source"))
+	rootCmd.PersistentFlags().String("source-v4", "", "address source to use for ipv4 (default --source)")
+	viper.BindPFlag("ddns.source_v4", rootCmd.PersistentFlags().Lookup("source-v4"))
+	rootCmd.PersistentFlags().String("source-v6", "", "address source to use for ipv6 (default --source)")
+	viper.BindPFlag("ddns.source_v6", rootCmd.PersistentFlags().Lookup("source-v6"))
+	rootCmd.PersistentFlags().StringSlice("stun-servers", stun.DefaultServers, "stun servers (host:port) to query when using the stun address source")
+	viper.BindPFlag("ddns.stun.servers", rootCmd.PersistentFlags().Lookup("stun-servers"))
+	rootCmd.PersistentFlags().String("iface", "", "network interface to take addresses from when using the interface address source")
+	viper.BindPFlag("ddns.interface.name", rootCmd.PersistentFlags().Lookup("iface"))
+	rootCmd.PersistentFlags().StringSlice("iface-scopes", []string{"global"}, "address scopes allowed from the interface (global, ula, private, link-local, loopback)")
+	viper.BindPFlag("ddns.interface.scopes", rootCmd.PersistentFlags().Lookup("iface-scopes"))
+	rootCmd.PersistentFlags().String("iface-temporary", "stable", "which ipv6 addresses to take from the interface (stable, temporary, any)")
+	viper.BindPFlag("ddns.interface.temporary", rootCmd.PersistentFlags().Lookup("iface-temporary"))
+	rootCmd.PersistentFlags().String("gateway-protocol", "auto", "protocol used to ask the gateway for its external address (auto, upnp, natpmp, pcp)")
+	viper.BindPFlag("ddns.gateway.protocol", rootCmd.PersistentFlags().Lookup("gateway-protocol"))
+	rootCmd.PersistentFlags().String("gateway-address", "", "address of the gateway for nat-pmp and pcp (default is the default route)")
+	viper.BindPFlag("ddns.gateway.address", rootCmd.PersistentFlags().Lookup("gateway-address"))
+	rootCmd.PersistentFlags().String("gateway-location", "", "upnp device description url of the gateway (default is found with ssdp)")
+	viper.BindPFlag("ddns.gateway.location", rootCmd.PersistentFlags().Lookup("gateway-location"))
+	rootCmd.PersistentFlags().String("watch", "", "network interface to watch for address changes, triggering an immediate check (linux only)")
+	viper.BindPFlag("ddns.watch", rootCmd.PersistentFlags().Lookup("watch"))
+	rootCmd.PersistentFlags().Int("prefix-length", 64, "length of the delegated ipv6 prefix that host suffixes are combined with")
+	viper.BindPFlag("ddns.prefix_length", rootCmd.PersistentFlags().Lookup("prefix-length"))
+	rootCmd.PersistentFlags().Bool("validate", true, "reject looked up addresses in private, shared, link-local, documentation and other reserved ranges")
+	viper.BindPFlag("ddns.validate", rootCmd.PersistentFlags().Lookup("validate"))
+	rootCmd.PersistentFlags().StringSlice("allow", []string{}, "prefixes that are accepted even if they are in a reserved range (e.g. 100.64.0.0/10)")
+	viper.BindPFlag("ddns.allow", rootCmd.PersistentFlags().Lookup("allow"))
+	rootCmd.PersistentFlags().Int("confirmations", 2, "number of consecutive lookups a changed address must be seen in before records are updated")
+	viper.BindPFlag("ddns.confirmations", rootCmd.PersistentFlags().Lookup("confirmations"))
+
+	// dyndns
+	rootCmd.PersistentFlags().String("dyndns-listen", "", "address to serve the dyndns2 update endpoint on (e.g. :8245), disabled if empty")
+	viper.BindPFlag("dyndns.listen", rootCmd.PersistentFlags().Lookup("dyndns-listen"))
+	rootCmd.PersistentFlags().String("dyndns-username", "", "basic auth username required by the dyndns2 update endpoint")
+	viper.BindPFlag("dyndns.username", rootCmd.PersistentFlags().Lookup("dyndns-username"))
+	rootCmd.PersistentFlags().String("dyndns-password", "", "basic auth password required by the dyndns2 update endpoint")
+	viper.BindPFlag("dyndns.password", rootCmd.PersistentFlags().Lookup("dyndns-password"))
+	rootCmd.PersistentFlags().StringSlice("dyndns-hostnames", []string{}, "hostnames accepted by the dyndns2 update endpoint (default any)")
+	viper.BindPFlag("dyndns.hostnames", rootCmd.PersistentFlags().Lookup("dyndns-hostnames"))
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer(`.`, `_`))
 	viper.AutomaticEnv()
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"maps"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+)
+
+var (
+	migrateOwnerCmd = &cobra.Command{
+		Use:   "migrate-owner",
+		Short: "move the records owned by one instance over to another, leaving them in place, then exit",
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if err := migrateOwner(from, to, dryRun); err != nil {
+				logrus.WithError(err).Fatalln("could not migrate records")
+			}
+		},
+	}
+)
+
+func init() {
+	migrateOwnerCmd.Flags().String("from", "", "name of the instance that owns the records")
+	migrateOwnerCmd.Flags().String("to", "", "name of the instance to give the records to")
+	migrateOwnerCmd.Flags().Bool("dry-run", false, "only list the records that would be migrated")
+	migrateOwnerCmd.MarkFlagRequired("from")
+	migrateOwnerCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(migrateOwnerCmd)
+}
+
+// migrateOwner rewrites the tag in the comment of each record owned by one
+// instance to name another, and moves any other ownership of them over too.
+// The records themselves are updated in place, so nothing is deleted or
+// recreated. With loadbalancer.account set, the origins of the instance in
+// load balancing pools are renamed too.
+func migrateOwner(from, to string, dryRun bool) error {
+	if from == "" || to == "" {
+		return errors.New("both the instance to migrate from and to must be given")
+	}
+	if from == to {
+		return errors.New("the instances to migrate from and to must differ")
+	}
+	c, err := newCloudflare()
+	if err != nil {
+		return err
+	}
+	cfZones, err := c.GetZones()
+	if err != nil {
+		return err
+	}
+	migrated := 0
+	for _, zoneID := range cfZones {
+		records, err := c.GetRecords(zoneID)
+		if err != nil {
+			return err
+		}
+		old, err := newOwnership(c, zoneID, records, owner{instance: from}, nil)
+		if err != nil {
+			return err
+		}
+		own, err := newOwnership(c, zoneID, records, owner{instance: to, aliases: []string{from}}, nil)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if !old.owns(record) {
+				continue
+			}
+			if dryRun {
+				logrus.WithField("record", record).Infoln("record would be migrated")
+				migrated++
+				continue
+			}
+			if comment := cf.RetagComment(record.Comment, to); comment != record.Comment {
+				if _, err := c.UpdateRecord(zoneID, record.ID, cf.RecordSpec{
+					Type:    record.Type,
+					Name:    record.Name,
+					Content: record.Address,
+					Proxied: record.Proxied,
+					TTL:     record.TTL,
+					Comment: comment,
+				}); err != nil {
+					logrus.WithError(err).WithField("record", record).Errorln("could not migrate record")
+					continue
+				}
+			}
+			if err := own.claim(record); err != nil {
+				logrus.WithError(err).WithField("record", record).Errorln("could not migrate record")
+				continue
+			}
+			logrus.WithField("record", record).Infoln("record migrated")
+			migrated++
+		}
+	}
+	logrus.WithField("from", from).WithField("to", to).WithField("count", migrated).WithField("dry_run", dryRun).Infoln("records migrated")
+	if accountID := viper.GetString("loadbalancer.account"); accountID != "" {
+		return migratePools(c, accountID, from, to, dryRun)
+	}
+	return nil
+}
+
+// migratePools renames the origins of one instance in the load balancing
+// pools of the account to those of another, keeping their addresses.
+func migratePools(c *cf.Cloudflare, accountID, from, to string, dryRun bool) error {
+	pools, err := c.GetPools(accountID)
+	if err != nil {
+		return err
+	}
+	migrated := 0
+	for _, pool := range pools {
+		origins := maps.Clone(pool.Origins)
+		renameOrigins(origins, from, to)
+		if maps.Equal(origins, pool.Origins) {
+			continue
+		}
+		if dryRun {
+			logrus.WithField("domain", pool.Hostname).Infoln("load balancing pool would be migrated")
+			migrated++
+			continue
+		}
+		pool.Origins = origins
+		if err := c.UpdatePoolOrigins(accountID, pool); err != nil {
+			logrus.WithError(err).WithField("domain", pool.Hostname).Errorln("could not migrate load balancing pool")
+			continue
+		}
+		logrus.WithField("domain", pool.Hostname).WithField("origins", origins).Infoln("load balancing pool migrated")
+		migrated++
+	}
+	logrus.WithField("from", from).WithField("to", to).WithField("count", migrated).WithField("dry_run", dryRun).Infoln("load balancing pools migrated")
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+// ownership decides which records of a zone are managed by this instance, and
+// which are managed by any instance of cloudflaere.
+type ownership interface {
+	// owns reports whether the record is managed by this instance.
+	owns(record *cf.Record) bool
+	// managed reports whether the record is managed by any instance.
+	managed(record *cf.Record) bool
+	// claim marks a record (such as one just created) as owned by this
+	// instance. Claiming a record that is already owned does nothing.
+	claim(record *cf.Record) error
+	// release gives up the ownership of a record, such as one that has been
+	// deleted.
+	release(record *cf.Record) error
+	// prune drops any ownership held of records that no longer exist, given
+	// every record in the zone.
+	prune(records []*cf.Record) error
+}
+
+// owner is the name of an instance, along with any names it was known by
+// before, whose records it owns too.
+type owner struct {
+	instance string
+	aliases  []string
+}
+
+// currentOwner returns the owner for this instance, with the aliases set by
+// instance_aliases in the config.
+func currentOwner() owner {
+	return owner{
+		instance: instanceName(),
+		aliases:  viper.GetStringSlice("instance_aliases"),
+	}
+}
+
+// is reports whether the instance is the owner, or one of its aliases.
+func (o owner) is(instance string) bool {
+	return instance == o.instance || slices.Contains(o.aliases, instance)
+}
+
+// newOwnership returns the ownership of the records of a zone, as set by
+// registry.type in the config.
+func newOwnership(c *cf.Cloudflare, zoneID string, records []*cf.Record, o owner, domainRouters map[string][]tr.TraefikRouter) (ownership, error) {
+	switch registry := viper.GetString("registry.type"); registry {
+	case "", "comment":
+		return &commentOwnership{owner: o}, nil
+	case "txt":
+		return newTXTRegistry(c, zoneID, records, o, domainRouters), nil
+	default:
+		return nil, fmt.Errorf("unknown registry type: %s", registry)
+	}
+}
+
+// commentOwner returns the instance named by the tag in the comment of a
+// record, or false if the comment has no tag.
+func commentOwner(record *cf.Record) (string, bool) {
+	tag, _, _, ok := cf.ParseTag(record.Comment)
+	return tag.Instance, ok
+}
+
+// commentOwnership takes records tagged with this instance (or one of its
+// aliases) in their comment as owned. As the comment is on the record itself,
+// nothing needs to be done to claim or release a record. The tags of records
+// owned through an alias are rewritten when the records are next synced, as
+// their comment then differs from the spec.
+type commentOwnership struct {
+	owner owner
+}
+
+func (o *commentOwnership) owns(record *cf.Record) bool {
+	instance, ok := commentOwner(record)
+	return ok && o.owner.is(instance)
+}
+
+func (o *commentOwnership) managed(record *cf.Record) bool {
+	_, ok := commentOwner(record)
+	return ok
+}
+
+func (o *commentOwnership) claim(*cf.Record) error   { return nil }
+func (o *commentOwnership) release(*cf.Record) error { return nil }
+func (o *commentOwnership) prune([]*cf.Record) error { return nil }
+
+// txtRegistry takes records as owned when a registry TXT record (see
+// cf.RegistryEntry) names this instance as their owner. With registry.migrate
+// set, records tagged with this instance in their comment are owned too, and are
+// given a registry entry the next time they are synced.
+type txtRegistry struct {
+	c             *cf.Cloudflare
+	zoneID        string
+	owner         owner
+	migrate       bool
+	domainRouters map[string][]tr.TraefikRouter
+
+	// entries maps record IDs to their registry entries, and the TXT records
+	// they are held in.
+	entries map[string]registryRecord
+}
+
+type registryRecord struct {
+	entry cf.RegistryEntry
+	txt   *cf.Record
+}
+
+func newTXTRegistry(c *cf.Cloudflare, zoneID string, records []*cf.Record, o owner, domainRouters map[string][]tr.TraefikRouter) *txtRegistry {
+	r := &txtRegistry{
+		c:             c,
+		zoneID:        zoneID,
+		owner:         o,
+		migrate:       viper.GetBool("registry.migrate"),
+		domainRouters: domainRouters,
+		entries:       make(map[string]registryRecord),
+	}
+	for _, record := range records {
+		if record.Type != "TXT" || !cf.IsRegistryName(record.Name) {
+			continue
+		}
+		entry, err := cf.ParseRegistryEntry(record.Address)
+		if err != nil {
+			logrus.WithError(err).WithField("record", record).Debugln("ignoring registry record")
+			continue
+		}
+		if _, ok := r.entries[entry.RecordID]; !ok {
+			r.entries[entry.RecordID] = registryRecord{entry: entry, txt: record}
+		}
+	}
+	return r
+}
+
+func (r *txtRegistry) owns(record *cf.Record) bool {
+	if rr, ok := r.entries[record.ID]; ok {
+		return r.owner.is(rr.entry.Instance)
+	}
+	instance, ok := commentOwner(record)
+	return r.migrate && ok && r.owner.is(instance)
+}
+
+func (r *txtRegistry) managed(record *cf.Record) bool {
+	if _, ok := r.entries[record.ID]; ok {
+		return true
+	}
+	_, ok := commentOwner(record)
+	return r.migrate && ok
+}
+
+func (r *txtRegistry) claim(record *cf.Record) error {
+	if rr, ok := r.entries[record.ID]; ok {
+		if rr.entry.Instance == r.owner.instance {
+			return nil
+		}
+		// Record is owned through an alias -> replace the entry
+		if err := r.release(record); err != nil {
+			return err
+		}
+	}
+	entry := cf.RegistryEntry{
+		Instance: r.owner.instance,
+		Type:     record.Type,
+		RecordID: record.ID,
+	}
+	if routers := r.domainRouters[strings.ToLower(record.Name)]; len(routers) > 0 {
+		entry.Router = routers[0].Name
+	}
+	txt, err := r.c.CreateRecord(r.zoneID, cf.RecordSpec{
+		Type:    "TXT",
+		Name:    cf.RegistryName(record.Name),
+		Content: entry.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not create registry record: %w", err)
+	}
+	r.entries[record.ID] = registryRecord{entry: entry, txt: txt}
+	return nil
+}
+
+func (r *txtRegistry) release(record *cf.Record) error {
+	rr, ok := r.entries[record.ID]
+	if !ok || !r.owner.is(rr.entry.Instance) {
+		return nil
+	}
+	if err := r.c.DeleteRecord(r.zoneID, rr.txt.ID); err != nil {
+		return fmt.Errorf("could not delete registry record: %w", err)
+	}
+	delete(r.entries, record.ID)
+	return nil
+}
+
+func (r *txtRegistry) prune(records []*cf.Record) error {
+	exists := make(map[string]bool)
+	for _, record := range records {
+		exists[record.ID] = true
+	}
+	for id, rr := range r.entries {
+		if exists[id] || !r.owner.is(rr.entry.Instance) {
+			continue
+		}
+		if err := r.c.DeleteRecord(r.zoneID, rr.txt.ID); err != nil {
+			return fmt.Errorf("could not delete stale registry record: %w", err)
+		}
+		delete(r.entries, id)
+	}
+	return nil
+}
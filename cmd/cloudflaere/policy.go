@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/match"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+type policyConfig struct {
+	match.RuleConfig `mapstructure:",squash"`
+	Proxied          *bool    `mapstructure:"proxied"`
+	TTL              *int     `mapstructure:"ttl"`
+	Types            []string `mapstructure:"types"`
+}
+
+// policy overrides how records are published for any domain that matches the
+// rule. Only the fields that are set are overridden.
+type policy struct {
+	rule    *match.Rule
+	proxied *bool
+	ttl     *int
+	types   []string
+}
+
+// recordPolicy is how the records of a single domain should be published.
+type recordPolicy struct {
+	proxied bool
+	ttl     int
+	types   map[string]bool
+}
+
+// loadPolicies reads the record policies from the config.
+func loadPolicies() ([]policy, error) {
+	var configs []policyConfig
+	if err := viper.UnmarshalKey("policies", &configs); err != nil {
+		return nil, fmt.Errorf("could not parse policies: %w", err)
+	}
+	policies := make([]policy, len(configs))
+	for i, config := range configs {
+		rule, err := config.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse policy rule: %w", err)
+		}
+		for _, t := range config.Types {
+			if t != "A" && t != "AAAA" {
+				return nil, fmt.Errorf("policy record type must be A or AAAA, not %s", t)
+			}
+		}
+		policies[i] = policy{
+			rule:    rule,
+			proxied: config.Proxied,
+			ttl:     config.TTL,
+			types:   config.Types,
+		}
+	}
+	return policies, nil
+}
+
+// resolvePolicy returns the policy for a domain. This starts from the global
+// cloudflare.proxied and cloudflare.ttl options with both A and AAAA records,
+// and then each matching policy is applied in the order they are configured,
+// so later policies take precedence.
+func resolvePolicy(subject match.Subject, policies []policy) recordPolicy {
+	rp := recordPolicy{
+		proxied: viper.GetBool("cloudflare.proxied"),
+		ttl:     viper.GetInt("cloudflare.ttl"),
+		types:   map[string]bool{"A": true, "AAAA": true},
+	}
+	for _, p := range policies {
+		if !p.rule.Match(subject) {
+			continue
+		}
+		if p.proxied != nil {
+			rp.proxied = *p.proxied
+		}
+		if p.ttl != nil {
+			rp.ttl = *p.ttl
+		}
+		if p.types != nil {
+			rp.types = make(map[string]bool)
+			for _, t := range p.types {
+				rp.types[t] = true
+			}
+		}
+	}
+	return rp
+}
+
+// domainSubject returns the attributes of the domain that rules are matched
+// against, taken from the routers that use it.
+func domainSubject(domain string, routers []tr.TraefikRouter) match.Subject {
+	subject := match.Subject{Domain: domain}
+	for _, router := range routers {
+		subject.Routers = append(subject.Routers, router.Name)
+		subject.Providers = append(subject.Providers, router.Provider)
+		subject.EntryPoints = append(subject.EntryPoints, router.EntryPoints...)
+	}
+	return subject
+}
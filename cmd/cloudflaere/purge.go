@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	purgeCmd = &cobra.Command{
+		Use:   "purge",
+		Short: "delete every record owned by this instance (see --instance), then exit",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			yes, _ := cmd.Flags().GetBool("yes")
+			if err := purge(dryRun, yes); err != nil {
+				logrus.WithError(err).Fatalln("could not purge records")
+			}
+		},
+	}
+)
+
+func init() {
+	purgeCmd.Flags().Bool("dry-run", false, "only list the records that would be deleted")
+	purgeCmd.Flags().BoolP("yes", "y", false, "delete the records without asking for confirmation")
+	rootCmd.AddCommand(purgeCmd)
+}
+
+// purge deletes every record owned by this instance, such as when the host it
+// ran on is decommissioned. Unless yes is set, the records are listed and
+// confirmation is asked for first.
+func purge(dryRun, yes bool) error {
+	c, err := newCloudflare()
+	if err != nil {
+		return err
+	}
+	instance := instanceName()
+	zones, err := ownedRecords(c, instance)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, zone := range zones {
+		for _, record := range zone.records {
+			logrus.WithField("record", record).Infoln("record owned by instance")
+			count++
+		}
+	}
+	if dryRun || count == 0 {
+		logrus.WithField("instance", instance).WithField("count", count).WithField("dry_run", dryRun).Infoln("records purged")
+		return nil
+	}
+	if !yes && !confirm(fmt.Sprintf("delete %d records owned by %s?", count, instance)) {
+		return fmt.Errorf("purge was not confirmed")
+	}
+	purged := 0
+	for _, zone := range zones {
+		for _, record := range zone.records {
+			if err := c.DeleteRecord(zone.zoneID, record.ID); err != nil {
+				logrus.WithError(err).WithField("record", record).Errorln("could not delete record")
+				continue
+			}
+			if err := zone.own.release(record); err != nil {
+				logrus.WithError(err).WithField("record", record).Warnln("could not release record")
+			}
+			logrus.WithField("record", record).Debugln("record deleted")
+			purged++
+		}
+	}
+	logrus.WithField("instance", instance).WithField("count", purged).WithField("dry_run", dryRun).Infoln("records purged")
+	return nil
+}
+
+// confirm asks a yes or no question on the terminal, returning true only if
+// the answer is yes.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
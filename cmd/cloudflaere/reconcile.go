@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+// reconcile brings the cloudflare records in line with the domains currently
+// found on traefik and the current addresses of this host.
+func reconcile() {
+	// CONFIGURE CLIENTS
+	c, err := newCloudflare()
+	if err != nil {
+		logrus.WithError(err).Errorln("cloudflare api client could not be created")
+		return
+	}
+	t, err := tr.NewTraefik(viper.GetString("traefik.url"))
+	if err != nil {
+		logrus.WithError(err).Errorln("traefik api client could not be created")
+		return
+	}
+
+	// GET DOMAINS FROM TRAEFIK
+	trDomains, domainRouters, err := fetchDomains(t)
+	if err != nil {
+		logrus.WithError(err).Errorln("could not fetch domains from traefik")
+		return
+	}
+	if len(trDomains) == 0 {
+		logrus.Warnln("no domains found in traefik")
+		return
+	}
+
+	// GATE DOMAINS ON SERVICE HEALTH
+	// the records of domains whose servers are all down are withdrawn, and are
+	// published again once any server recovers
+	if viper.GetBool("traefik.health") {
+		health, err := serviceHealth(t)
+		if err != nil {
+			logrus.WithError(err).Errorln("could not fetch service health from traefik")
+			return
+		}
+		healthyDomains := make([]tr.Domain, 0)
+		for _, domain := range trDomains {
+			if !domainHealthy(domainRouters[domain.String()], health) {
+				logrus.WithField("domain", domain).Warnln("withdrawing domain as all of its servers are down")
+				continue
+			}
+			healthyDomains = append(healthyDomains, domain)
+		}
+		trDomains = healthyDomains
+	}
+
+	// GATE DOMAINS ON HEALTH PROBES
+	probes, err := loadProbes()
+	if err != nil {
+		logrus.WithError(err).Errorln("could not load health probes")
+		return
+	}
+	if len(probes) > 0 {
+		results := probeDomains(trDomains, domainRouters, probes)
+		healthyDomains := make([]tr.Domain, 0)
+		for _, domain := range trDomains {
+			if healthy, probed := results[domain.String()]; probed && !healthy {
+				logrus.WithField("domain", domain).Warnln("withdrawing domain as it is failing health probes")
+				continue
+			}
+			healthyDomains = append(healthyDomains, domain)
+		}
+		trDomains = healthyDomains
+	}
+
+	// GET ZONES FROM CLOUDFLARE
+	cfZones, err := c.GetZones()
+	if err != nil {
+		logrus.WithError(err).Errorln("could not fetch zones from cloudflare")
+		return
+	}
+	logrus.WithField("count", len(cfZones)).Infoln("zones fetched from cloudflare")
+	if len(cfZones) == 0 {
+		logrus.Warnln("no zones found in cloudflare")
+		return
+	}
+
+	// DOMAIN ZONE BUCKETS
+	domainZones := make(map[string][]string)
+	unmatched := make([]string, 0)
+	for _, domain := range trDomains {
+		logrus.WithField("domain", domain).Debugln("processing domain")
+		zoneName, zoneID, ok := cf.ZoneFor(cfZones, domain.String())
+		if !ok {
+			logrus.WithField("domain", domain).Debugln("domain is not in any cloudflare zone")
+			unmatched = append(unmatched, domain.String())
+			continue
+		}
+		logrus.WithField("domain", domain).WithField("zone", zoneName).Debugln("domain matched to zone")
+		domainZones[zoneID] = append(domainZones[zoneID], domain.String())
+	}
+	if len(unmatched) > 0 {
+		logrus.WithField("count", len(unmatched)).WithField("domains", unmatched).Warnln("domains with no matching cloudflare zone")
+	}
+
+	// CREATE MAGIC COMMENT
+	magicCommentKey := instanceName()
+
+	// GET ADDRESSES
+	// records made in tunnel mode point at the tunnel, so need no address
+	addresses, unavailable := make(map[string]netip.Addr), make(map[string]bool)
+	if viper.GetString("mode") != "tunnel" {
+		if !viper.GetBool("ddns.ipv4") && !viper.GetBool("ddns.ipv6") {
+			logrus.Warnln("no address families are enabled (see ddns.ipv4 and ddns.ipv6)")
+			return
+		}
+		addresses, unavailable = lookupAddresses()
+		if len(addresses) == 0 && len(unavailable) > 0 {
+			logrus.Warnln("no addresses are available")
+			return
+		}
+	}
+
+	hostSuffixes, err := loadHostSuffixes()
+	if err != nil {
+		logrus.WithError(err).Errorln("could not load host suffixes")
+		return
+	}
+	policies, err := loadPolicies()
+	if err != nil {
+		logrus.WithError(err).Errorln("could not load policies")
+		return
+	}
+
+	// DESIRED RECORDS
+	p := &plan{
+		zones:         cfZones,
+		domainZones:   domainZones,
+		domainRouters: domainRouters,
+		addresses:     addresses,
+		hostSuffixes:  hostSuffixes,
+		policies:      policies,
+		instance:      magicCommentKey,
+		now:           time.Now(),
+	}
+	zoneSpecs, err := p.records()
+	if err != nil {
+		logrus.WithError(err).Errorln("could not determine desired records")
+		return
+	}
+
+	// FOR EACH ZONE
+	// zones with records wanted, or with records owned when last visited, are
+	// visited every interval. Every zone is visited each sweep, so that owned
+	// records in zones without domains (such as those of domains removed from
+	// traefik while not running) are cleaned up too
+	fullSweep := p.now.Sub(lastSweep) >= viper.GetDuration("sweep")
+	if fullSweep {
+		lastSweep = p.now
+	}
+	summary := &report{}
+	ownedNames := make([]string, 0)
+	for _, zoneID := range cfZones {
+		if _, wanted := zoneSpecs[zoneID]; !fullSweep && !wanted && !ownedZones[zoneID] {
+			continue
+		}
+		records, err := c.GetRecords(zoneID)
+		if err != nil {
+			logrus.WithError(err).WithField("zone_id", zoneID).WithField("domains", len(domainZones[zoneID])).Errorln("could not fetch records from cloudflare")
+			continue
+		}
+		logrus.WithField("zone_id", zoneID).WithField("records", len(records)).Debugln("records fetched from cloudflare")
+		own, err := newOwnership(c, zoneID, records, currentOwner(), domainRouters)
+		if err != nil {
+			logrus.WithError(err).Errorln("could not determine record ownership")
+			return
+		}
+		delete(ownedZones, zoneID)
+		for _, record := range records {
+			if own.owns(record) {
+				ownedNames = append(ownedNames, record.Name)
+				ownedZones[zoneID] = true
+			}
+		}
+		syncRecords(c, zoneID, records, zoneSpecs[zoneID], own, unavailable, summary)
+	}
+	logrus.WithFields(summary.fields()).Infoln("records reconciled")
+
+	// SYNC LOAD BALANCERS
+	if viper.GetString("mode") == "loadbalancer" {
+		syncLoadBalancers(c, p, currentOwner(), unavailable)
+	}
+
+	// SYNC TUNNEL INGRESS
+	if viper.GetString("mode") == "tunnel" && viper.GetBool("tunnel.ingress") {
+		syncIngress(c, domainZones, ownedNames)
+	}
+}
+
+// newCloudflare creates a cloudflare api client, limited to the zones and
+// accounts set in the config.
+func newCloudflare() (*cf.Cloudflare, error) {
+	opts := make([]cloudflare.Option, 0)
+	if apiURL := viper.GetString("cloudflare.api_url"); apiURL != "" {
+		opts = append(opts, cloudflare.BaseURL(apiURL))
+	}
+	c, err := cf.NewCloudflare(viper.GetString("cloudflare.zone"), viper.GetString("cloudflare.dns"), opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.SetAllowedZones(viper.GetStringSlice("cloudflare.zones.allow")...)
+	c.SetAllowedZoneIDs(viper.GetStringSlice("cloudflare.zones.ids")...)
+	c.SetDeniedZones(viper.GetStringSlice("cloudflare.zones.deny")...)
+	c.SetAllowedAccounts(viper.GetStringSlice("cloudflare.accounts")...)
+	return c, nil
+}
+
+// instanceName returns the name of this instance, used to mark the records
+// it owns.
+func instanceName() string {
+	instance := viper.GetString("instance")
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	return instance
+}
+
+// fetchDomains returns the normalised domains of every traefik router that
+// pass the domain filter, along with the routers using each domain.
+func fetchDomains(t *tr.Traefik) ([]tr.Domain, map[string][]tr.TraefikRouter, error) {
+	trRouters, err := t.GetRouters()
+	if err != nil {
+		return nil, nil, err
+	}
+	trDomains := make([]tr.Domain, 0)
+	domainRouters := make(map[string][]tr.TraefikRouter)
+	for _, router := range trRouters {
+		ds, err := router.Domains()
+		if err != nil {
+			logrus.WithError(err).WithField("router", router.Name).Warnln("could not parse domains from router rule")
+			continue
+		}
+		for _, d := range ds {
+			if d, err = d.Normalize(); err != nil {
+				logrus.WithError(err).WithField("router", router.Name).Warnln("dropping invalid domain from router rule")
+				continue
+			}
+			if _, ok := domainRouters[d.String()]; !ok {
+				trDomains = append(trDomains, d)
+			}
+			domainRouters[d.String()] = append(domainRouters[d.String()], router)
+		}
+	}
+	logrus.WithField("count", len(trDomains)).Infoln("domains fetched from traefik")
+
+	filter, err := loadDomainFilter()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load domain filter: %w", err)
+	}
+	filteredDomains := make([]tr.Domain, 0)
+	for _, domain := range trDomains {
+		if !filter.allowed(domainSubject(domain.String(), domainRouters[domain.String()])) {
+			logrus.WithField("domain", domain).Debugln("domain filtered out")
+			continue
+		}
+		filteredDomains = append(filteredDomains, domain)
+	}
+	if len(filteredDomains) != len(trDomains) {
+		logrus.WithField("count", len(trDomains)-len(filteredDomains)).Infoln("domains filtered out")
+	}
+	return filteredDomains, domainRouters, nil
+}
+
+// lastSweep is when every zone was last visited.
+var lastSweep time.Time
+
+// ownedZones holds the zones this instance owned records in when they were
+// last visited, which are visited every interval until none are left.
+var ownedZones = make(map[string]bool)
+
+// ingressSynced holds the hostnames routed through the tunnel by the last sync
+// of its ingress config.
+var ingressSynced []string
+
+// syncIngress routes every managed domain through the tunnel to traefik. Only
+// ingress rules for hostnames this instance owns records of (before they were
+// synced), or routed by an earlier sync, are replaced, so rules made by hand
+// are left alone.
+func syncIngress(c *cf.Cloudflare, domainZones map[string][]string, owned []string) {
+	accountID, service := viper.GetString("tunnel.account"), viper.GetString("tunnel.service")
+	if accountID == "" || service == "" {
+		logrus.Errorln("tunnel.account and tunnel.service must be set to sync tunnel ingress")
+		return
+	}
+	hostnames := make([]string, 0)
+	for _, domains := range domainZones {
+		hostnames = append(hostnames, domains...)
+	}
+	managed := append(slices.Clone(owned), ingressSynced...)
+	changed, shadowed, err := c.SyncTunnelIngress(accountID, viper.GetString("tunnel.id"), service, hostnames, managed)
+	if err != nil {
+		logrus.WithError(err).Errorln("could not sync tunnel ingress")
+		return
+	}
+	if len(shadowed) > 0 {
+		logrus.WithField("hostnames", shadowed).Warnln("tunnel ingress rules made by hand route these domains elsewhere")
+	}
+	ingressSynced = hostnames
+	logrus.WithField("hostnames", len(hostnames)).WithField("changed", changed).Infoln("tunnel ingress synced")
+}
+
+// report counts the changes made to records while reconciling.
+type report struct {
+	created   int
+	updated   int
+	deleted   int
+	unchanged int
+	failed    int
+}
+
+func (r *report) fields() logrus.Fields {
+	return logrus.Fields{
+		"created":   r.created,
+		"updated":   r.updated,
+		"deleted":   r.deleted,
+		"unchanged": r.unchanged,
+		"failed":    r.failed,
+	}
+}
+
+// syncRecords makes the records of the zone match the given specs. Owned
+// records that have no spec are deleted, unless they are of a type in keep.
+// Records that do not exist are then created, and owned records that differ
+// from their spec are updated.
+func syncRecords(c *cf.Cloudflare, zoneID string, records []*cf.Record, specs []cf.RecordSpec, own ownership, keep map[string]bool, summary *report) {
+	if err := own.prune(records); err != nil {
+		logrus.WithError(err).WithField("zone_id", zoneID).Warnln("could not prune record ownership")
+	}
+
+	// CLEAN
+	// unwanted records are removed first, as they may otherwise conflict with
+	// records to be created (such as an A record where a CNAME is now wanted)
+	remaining := make([]*cf.Record, 0)
+	for _, record := range records {
+		if keep[record.Type] || !own.owns(record) {
+			remaining = append(remaining, record)
+			continue
+		}
+		hasSpec := false
+		for _, spec := range specs {
+			if cf.SameName(record.Name, spec.Name) && record.Type == spec.Type {
+				hasSpec = true
+				break
+			}
+		}
+		if hasSpec {
+			remaining = append(remaining, record)
+			continue
+		}
+		// Record exists but is no longer wanted -> delete
+		if !deleteRecord(c, zoneID, record, own, summary) {
+			remaining = append(remaining, record)
+		}
+	}
+
+	// ADD & UPDATE
+	// several instances may publish records of the same name and type (such
+	// as an A record each, for round-robin), so each only looks after its own
+	for _, spec := range specs {
+		recs := c.FilterRecords(remaining, cf.RecordFilterNameIn(spec.Name), cf.RecordFilterTypeIn(spec.Type))
+		owned := make([]*cf.Record, 0)
+		for _, record := range recs {
+			if own.owns(record) {
+				owned = append(owned, record)
+			}
+		}
+		if record := adoptable(spec, recs, own); len(owned) == 0 && record != nil {
+			// Record exists but is unmanaged -> adopt
+			if err := adoptRecord(c, zoneID, record, spec.Comment, own); err != nil {
+				logrus.WithError(err).WithField("record", record).Errorln("could not adopt record")
+				summary.failed++
+				continue
+			}
+			logrus.WithField("record", record).Infoln("record adopted")
+			owned = append(owned, record)
+		}
+		if len(owned) == 0 {
+			if reason := shareBlocked(spec, recs, own); reason != "" {
+				logrus.WithField("domain", spec.Name).WithField("type", spec.Type).Debugln(reason)
+				continue
+			}
+			// Record not exist -> create
+			r, err := c.CreateRecord(zoneID, spec)
+			if err != nil {
+				logrus.WithError(err).WithField("domain", spec.Name).Errorln("could not add record")
+				summary.failed++
+				continue
+			}
+			if err := own.claim(r); err != nil {
+				// an unclaimed record would not be seen as managed by any
+				// instance, so could never be updated or cleaned up -> delete
+				logrus.WithError(err).WithField("record", r).Errorln("could not claim record")
+				summary.failed++
+				if err := c.DeleteRecord(zoneID, r.ID); err != nil {
+					logrus.WithError(err).WithField("record", r).Errorln("could not delete unclaimed record")
+				}
+				continue
+			}
+			logrus.WithField("record", r).WithField("domain", spec.Name).Debugln("record created")
+			summary.created++
+			continue
+		}
+		for _, duplicate := range owned[1:] {
+			// Record is duplicated -> delete all but the first
+			deleteRecord(c, zoneID, duplicate, own, summary)
+		}
+		record := owned[0]
+		if err := own.claim(record); err != nil {
+			logrus.WithError(err).WithField("record", record).Errorln("could not claim record")
+			summary.failed++
+		}
+		diff := cf.Diff(spec, record)
+		if !diff.Changed() {
+			logrus.WithField("domain", spec.Name).Debugln("record is up to date")
+			summary.unchanged++
+			continue
+		}
+		// Record exists but differs -> update, keeping any text added to the
+		// comment, and when the record was first created
+		if diff.Comment {
+			spec.Comment = cf.MergeComment(record.Comment, spec.Comment)
+		} else {
+			spec.Comment = record.Comment
+		}
+		if _, err := c.UpdateRecord(zoneID, record.ID, spec); err != nil {
+			logrus.WithError(err).WithField("domain", spec.Name).WithField("diff", diff).Errorln("could not update record")
+			summary.failed++
+		} else {
+			logrus.WithField("domain", spec.Name).WithField("diff", diff).Debugln("record updated")
+			summary.updated++
+		}
+	}
+}
+
+// deleteRecord deletes an owned record and releases its ownership, returning
+// whether the record was deleted.
+func deleteRecord(c *cf.Cloudflare, zoneID string, record *cf.Record, own ownership, summary *report) bool {
+	if err := c.DeleteRecord(zoneID, record.ID); err != nil {
+		logrus.WithError(err).WithField("record", record).Errorln("could not delete record")
+		summary.failed++
+		return false
+	}
+	logrus.WithField("record", record).Debugln("record deleted")
+	summary.deleted++
+	if err := own.release(record); err != nil {
+		logrus.WithError(err).WithField("record", record).Warnln("could not release record")
+	}
+	return true
+}
+
+// shareBlocked gives the reason a record can not be created for the spec
+// alongside the existing records of the same name and type, or an empty string
+// if it can. Records are only shared with other cloudflaere instances, and only
+// address records can be shared, as a name can have just one CNAME.
+func shareBlocked(spec cf.RecordSpec, existing []*cf.Record, own ownership) string {
+	for _, record := range existing {
+		if !own.managed(record) {
+			return "record is not managed by cloudflaere"
+		}
+		if spec.Type != "A" && spec.Type != "AAAA" {
+			return "record is managed by another instance"
+		}
+		if !cf.Diff(spec, record).Content {
+			return "address is already published by another instance"
+		}
+	}
+	return ""
+}
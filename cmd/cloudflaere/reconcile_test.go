@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+	"github.com/willfantom/cloudflaere/pkg/dyndns"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+// setConfig sets config values for the duration of the test.
+func setConfig(t *testing.T, values map[string]any) {
+	t.Helper()
+	for key, value := range values {
+		previous := viper.Get(key)
+		viper.Set(key, value)
+		t.Cleanup(func() { viper.Set(key, previous) })
+	}
+}
+
+// dnsAPI is a fake of the zone and DNS record endpoints of the cloudflare API
+// and of the router endpoints of the traefik API, so that whole reconciles can
+// be run against it.
+type dnsAPI struct {
+	t       *testing.T
+	lock    sync.Mutex
+	next    int
+	zones   map[string]string
+	records map[string]zoneRecord
+	routers []tr.TraefikRouter
+	// listed counts the record listings of each zone.
+	listed map[string]int
+}
+
+// zoneRecord is a DNS record held by dnsAPI, along with its zone.
+type zoneRecord struct {
+	cloudflare.DNSRecord
+	zoneID string
+}
+
+func (f *dnsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch r.URL.Path {
+	case "/api/version":
+		json.NewEncoder(w).Encode(tr.TraefikVersion{Version: "3.0.0"})
+		return
+	case "/api/http/routers":
+		json.NewEncoder(w).Encode(f.routers)
+		return
+	}
+	var result any
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/client/v4"), "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "zones" && r.Method == http.MethodGet:
+		zones := make([]cloudflare.Zone, 0)
+		for name, id := range f.zones {
+			zones = append(zones, cloudflare.Zone{ID: id, Name: name})
+		}
+		result = zones
+	case len(path) >= 3 && path[0] == "zones" && path[2] == "dns_records":
+		zoneID := path[1]
+		switch {
+		case len(path) == 3 && r.Method == http.MethodGet:
+			f.listed[zoneID]++
+			records := make([]cloudflare.DNSRecord, 0)
+			for _, record := range f.records {
+				if record.zoneID == zoneID {
+					records = append(records, record.DNSRecord)
+				}
+			}
+			slices.SortFunc(records, func(a, b cloudflare.DNSRecord) int { return strings.Compare(a.ID, b.ID) })
+			result = records
+		case len(path) == 3 && r.Method == http.MethodPost:
+			var record cloudflare.DNSRecord
+			json.NewDecoder(r.Body).Decode(&record)
+			f.next++
+			record.ID = fmt.Sprintf("r%02d", f.next)
+			f.records[record.ID] = zoneRecord{DNSRecord: record, zoneID: zoneID}
+			result = record
+		case len(path) == 4 && r.Method == http.MethodPatch:
+			record, ok := f.records[path[3]]
+			if !ok {
+				break
+			}
+			var update cloudflare.DNSRecord
+			json.NewDecoder(r.Body).Decode(&update)
+			if update.Content != "" {
+				record.Content = update.Content
+			}
+			if update.Comment != "" {
+				record.Comment = update.Comment
+			}
+			if update.Proxied != nil {
+				record.Proxied = update.Proxied
+			}
+			if update.TTL != 0 {
+				record.TTL = update.TTL
+			}
+			f.records[record.ID] = record
+			result = record.DNSRecord
+		case len(path) == 4 && r.Method == http.MethodDelete:
+			delete(f.records, path[3])
+			result = map[string]string{"id": path[3]}
+		}
+	}
+	if result == nil {
+		f.t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"success":     true,
+		"errors":      []any{},
+		"messages":    []any{},
+		"result":      result,
+		"result_info": map[string]int{"page": 1, "per_page": 100, "total_pages": 1, "count": 1, "total_count": 1},
+	})
+}
+
+// addRecord adds a record to the zone, owned by the instance if one is given.
+func (f *dnsAPI) addRecord(zoneID, recordType, name, content, instance string) {
+	f.next++
+	record := zoneRecord{zoneID: zoneID, DNSRecord: cloudflare.DNSRecord{ID: fmt.Sprintf("r%02d", f.next), Type: recordType, Name: name, Content: content, TTL: 1}}
+	if instance != "" {
+		record.Comment = cf.Tag{Version: cf.TagVersion, Instance: instance}.String()
+	}
+	f.records[record.ID] = record
+}
+
+// addRouter adds a traefik router for the hostname.
+func (f *dnsAPI) addRouter(hostname string) {
+	f.routers = append(f.routers, tr.TraefikRouter{
+		Name:     strings.Split(hostname, ".")[0] + "@docker",
+		Provider: "docker",
+		RuleStr:  "Host(`" + hostname + "`)",
+		Status:   "enabled",
+	})
+}
+
+// record returns the record of the type and name.
+func (f *dnsAPI) record(recordType, name string) (cloudflare.DNSRecord, bool) {
+	for _, record := range f.records {
+		if record.Type == recordType && record.Name == name {
+			return record.DNSRecord, true
+		}
+	}
+	return cloudflare.DNSRecord{}, false
+}
+
+// contents returns the contents of the records, each as "<type> <name>
+// <content>", sorted.
+func (f *dnsAPI) contents() []string {
+	contents := make([]string, 0)
+	for _, record := range f.records {
+		contents = append(contents, record.Type+" "+record.Name+" "+record.Content)
+	}
+	slices.Sort(contents)
+	return contents
+}
+
+// newDNSAPI starts a fake cloudflare and traefik API, with the zone
+// example.com (z1), and points the config at it for the duration of the test.
+// The given addresses are pushed to a dyndns server used as the address
+// source, so are taken as they are.
+func newDNSAPI(t *testing.T, addresses ...string) *dnsAPI {
+	t.Helper()
+	f := &dnsAPI{
+		t:       t,
+		zones:   map[string]string{"example.com": "z1"},
+		records: make(map[string]zoneRecord),
+		listed:  make(map[string]int),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	setConfig(t, map[string]any{
+		"cloudflare.api_url": server.URL + "/client/v4",
+		"cloudflare.zone":    "token",
+		"cloudflare.dns":     "token",
+		"traefik.url":        server.URL,
+		"instance":           "host1",
+		"ddns.source":        "dyndns",
+		"ddns.ipv4":          true,
+		"ddns.ipv6":          true,
+		"ddns.validate":      false,
+	})
+
+	sweep, owned := lastSweep, ownedZones
+	lastSweep, ownedZones = time.Time{}, make(map[string]bool)
+	t.Cleanup(func() { lastSweep, ownedZones = sweep, owned })
+
+	previous := pushServer
+	pushServer = dyndns.NewServer("user", "pass")
+	t.Cleanup(func() { pushServer = previous })
+	if len(addresses) > 0 {
+		r := httptest.NewRequest(http.MethodGet, dyndns.UpdatePath+"?hostname=host1&myip="+strings.Join(addresses, ","), nil)
+		r.SetBasicAuth("user", "pass")
+		pushServer.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	return f
+}
+
+func TestReconcileAddressFamilies(t *testing.T) {
+	v4, v6 := netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("2001:db8::1")
+	tests := []struct {
+		name      string
+		addresses []string
+		config    map[string]any
+		want      []string
+	}{
+		{
+			name:      "both families",
+			addresses: []string{v4.String(), v6.String()},
+			want: []string{
+				"A a.example.com " + v4.String(),
+				"A hand.example.com 203.0.113.5",
+				"AAAA a.example.com " + v6.String(),
+			},
+		},
+		{
+			// records of a disabled family are no longer wanted
+			name:      "family disabled",
+			addresses: []string{v4.String()},
+			config:    map[string]any{"ddns.ipv6": false},
+			want: []string{
+				"A a.example.com " + v4.String(),
+				"A hand.example.com 203.0.113.5",
+			},
+		},
+		{
+			// records of a family whose address can not be found are left
+			// as they are, even for domains no longer wanted
+			name:      "family unavailable",
+			addresses: []string{v4.String()},
+			want: []string{
+				"A a.example.com " + v4.String(),
+				"A hand.example.com 203.0.113.5",
+				"AAAA a.example.com 2001:db8::9",
+				"AAAA gone.example.com 2001:db8::9",
+			},
+		},
+		{
+			name:      "no family enabled",
+			addresses: []string{v4.String(), v6.String()},
+			config:    map[string]any{"ddns.ipv4": false, "ddns.ipv6": false},
+			want: []string{
+				"A a.example.com 198.51.100.9",
+				"A gone.example.com 198.51.100.9",
+				"A hand.example.com 203.0.113.5",
+				"AAAA a.example.com 2001:db8::9",
+				"AAAA gone.example.com 2001:db8::9",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newDNSAPI(t, tt.addresses...)
+			setConfig(t, tt.config)
+			f.addRouter("a.example.com")
+			f.addRecord("z1", "A", "a.example.com", "198.51.100.9", "host1")
+			f.addRecord("z1", "AAAA", "a.example.com", "2001:db8::9", "host1")
+			f.addRecord("z1", "A", "gone.example.com", "198.51.100.9", "host1")
+			f.addRecord("z1", "AAAA", "gone.example.com", "2001:db8::9", "host1")
+			f.addRecord("z1", "A", "hand.example.com", "203.0.113.5", "")
+			reconcile()
+			if got := f.contents(); !slices.Equal(got, tt.want) {
+				t.Fatalf("records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileAdoptLongComment(t *testing.T) {
+	f := newDNSAPI(t, "198.51.100.1")
+	setConfig(t, map[string]any{"adopt": true, "ddns.ipv6": false})
+	f.addRouter("a.example.com")
+	f.addRecord("z1", "A", "a.example.com", "198.51.100.9", "")
+	record := f.records["r01"]
+	record.Comment = strings.Repeat("n", cf.CommentLimit-5)
+	f.records["r01"] = record
+
+	reconcile()
+	comment := f.records["r01"].Comment
+	if len(comment) > cf.CommentLimit {
+		t.Fatalf("adopted record comment is %d characters, over the limit of %d", len(comment), cf.CommentLimit)
+	}
+	if tag, before, _, ok := cf.ParseTag(comment); !ok || tag.Instance != "host1" || !strings.HasPrefix(before, "nnnn") {
+		t.Fatalf("adopted record comment = %q, want the text followed by a tag for host1", comment)
+	}
+	if got := f.records["r01"].Content; got != "198.51.100.1" {
+		t.Fatalf("adopted record content = %s, want 198.51.100.1", got)
+	}
+}
+
+func TestReconcileTXTRegistryComments(t *testing.T) {
+	f := newDNSAPI(t, "198.51.100.1")
+	setConfig(t, map[string]any{"registry.type": "txt", "ddns.ipv6": false})
+	f.addRouter("a.example.com")
+	f.addRouter("b.example.com")
+	f.addRecord("z1", "A", "a.example.com", "198.51.100.9", "")
+	record := f.records["r01"]
+	record.Comment = "made by hand"
+	f.records["r01"] = record
+	entry := cf.RegistryEntry{Instance: "host1", Type: "A", RecordID: "r01"}
+	f.addRecord("z1", "TXT", cf.RegistryName("a.example.com"), entry.String(), "")
+
+	for range 2 {
+		reconcile()
+	}
+	if got, _ := f.record("A", "a.example.com"); got.Content != "198.51.100.1" || got.Comment != "made by hand" {
+		t.Fatalf("owned record = %s %q, want 198.51.100.1 %q", got.Content, got.Comment, "made by hand")
+	}
+	created, ok := f.record("A", "b.example.com")
+	if !ok {
+		t.Fatal("record not created")
+	}
+	if created.Comment != "" {
+		t.Fatalf("created record comment = %q, want none", created.Comment)
+	}
+	if _, ok := f.record("TXT", cf.RegistryName("b.example.com")); !ok {
+		t.Fatal("no registry entry made for the created record")
+	}
+}
+
+func TestReconcileSweep(t *testing.T) {
+	f := newDNSAPI(t, "198.51.100.1")
+	setConfig(t, map[string]any{"ddns.ipv6": false, "sweep": time.Hour})
+	f.zones["other.org"] = "z2"
+	f.zones["idle.net"] = "z3"
+	f.addRouter("a.example.com")
+	f.addRecord("z2", "A", "gone.other.org", "198.51.100.9", "host1")
+
+	// every zone is visited on the first interval
+	reconcile()
+	want := map[string]int{"z1": 1, "z2": 1, "z3": 1}
+	if !maps.Equal(f.listed, want) {
+		t.Fatalf("zones listed = %v, want %v", f.listed, want)
+	}
+	if _, ok := f.record("A", "gone.other.org"); ok {
+		t.Fatal("record of a domain no longer wanted not deleted")
+	}
+
+	// then only those with domains, and those with owned records when last
+	// visited (until none are left)
+	reconcile()
+	reconcile()
+	want = map[string]int{"z1": 3, "z2": 2, "z3": 1}
+	if !maps.Equal(f.listed, want) {
+		t.Fatalf("zones listed = %v, want %v", f.listed, want)
+	}
+
+	// until the next sweep
+	lastSweep = lastSweep.Add(-time.Hour)
+	reconcile()
+	want = map[string]int{"z1": 4, "z2": 3, "z3": 2}
+	if !maps.Equal(f.listed, want) {
+		t.Fatalf("zones listed = %v, want %v", f.listed, want)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+	"github.com/willfantom/cloudflaere/pkg/tr"
+)
+
+// plan holds everything needed to work out the records wanted in each zone.
+type plan struct {
+	zones         map[string]string
+	domainZones   map[string][]string
+	domainRouters map[string][]tr.TraefikRouter
+	addresses     map[string]netip.Addr
+	hostSuffixes  []hostSuffix
+	policies      []policy
+	instance      string
+	now           time.Time
+}
+
+// records returns the records wanted in each zone, keyed by zone ID, for the
+// configured mode.
+func (p *plan) records() (map[string][]cf.RecordSpec, error) {
+	switch mode := viper.GetString("mode"); mode {
+	case "", "address":
+		return p.addressRecords(), nil
+	case "cname":
+		return p.cnameRecords(viper.GetString("cname.host"))
+	case "tunnel":
+		return p.tunnelRecords(viper.GetString("tunnel.id"))
+	case "loadbalancer":
+		// domains are published by load balancers rather than records, so
+		// any records left from another mode are removed
+		return make(map[string][]cf.RecordSpec), nil
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", mode)
+	}
+}
+
+// addressRecords gives every domain its own A and/or AAAA records.
+func (p *plan) addressRecords() map[string][]cf.RecordSpec {
+	specs := make(map[string][]cf.RecordSpec)
+	for zoneID, domains := range p.domainZones {
+		for _, domain := range domains {
+			specs[zoneID] = append(specs[zoneID], p.addressSpecs(domain)...)
+		}
+	}
+	return specs
+}
+
+// cnameRecords gives the host a single set of A and/or AAAA records, and makes
+// every other domain a CNAME to the host. When the address changes, only the
+// host records then need to be updated.
+func (p *plan) cnameRecords(host string) (map[string][]cf.RecordSpec, error) {
+	if host == "" {
+		return nil, fmt.Errorf("cname.host must be set in cname mode")
+	}
+	hostDomain, err := tr.Domain(host).Normalize()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse cname host: %w", err)
+	}
+	host = hostDomain.String()
+	_, hostZoneID, ok := cf.ZoneFor(p.zones, host)
+	if !ok {
+		return nil, fmt.Errorf("cname host %s is not in any cloudflare zone", host)
+	}
+	specs := make(map[string][]cf.RecordSpec)
+	specs[hostZoneID] = append(specs[hostZoneID], p.addressSpecs(host)...)
+	for zoneID, domains := range p.domainZones {
+		for _, domain := range domains {
+			if domain == host {
+				continue
+			}
+			rp := resolvePolicy(domainSubject(domain, p.domainRouters[domain]), p.policies)
+			specs[zoneID] = append(specs[zoneID], cf.RecordSpec{
+				Type:    "CNAME",
+				Name:    domain,
+				Content: host,
+				Proxied: rp.proxied,
+				TTL:     rp.ttl,
+				Comment: p.comment(domain),
+			})
+		}
+	}
+	return specs, nil
+}
+
+// tunnelRecords makes every domain a proxied CNAME to the cloudflare tunnel
+// with the given ID, so no address is needed at all.
+func (p *plan) tunnelRecords(tunnelID string) (map[string][]cf.RecordSpec, error) {
+	if tunnelID == "" {
+		return nil, fmt.Errorf("tunnel.id must be set in tunnel mode")
+	}
+	specs := make(map[string][]cf.RecordSpec)
+	for zoneID, domains := range p.domainZones {
+		for _, domain := range domains {
+			rp := resolvePolicy(domainSubject(domain, p.domainRouters[domain]), p.policies)
+			specs[zoneID] = append(specs[zoneID], cf.RecordSpec{
+				Type:    "CNAME",
+				Name:    domain,
+				Content: cf.TunnelTarget(tunnelID),
+				Proxied: true,
+				TTL:     rp.ttl,
+				Comment: p.comment(domain),
+			})
+		}
+	}
+	return specs, nil
+}
+
+// comment returns the comment for the records of the domain. With the txt
+// registry, ownership is not held in the comment, so records are given none
+// (and the comments they have are left as they are).
+func (p *plan) comment(domain string) string {
+	if viper.GetString("registry.type") == "txt" {
+		return ""
+	}
+	return recordComment(p.instance, p.domainRouters[domain], p.now)
+}
+
+// recordComment returns the comment for records made by the instance, tagging
+// them as owned by it and made for the first of the routers.
+func recordComment(instance string, routers []tr.TraefikRouter, created time.Time) string {
+	tag := cf.Tag{
+		Instance: instance,
+		Created:  created,
+	}
+	if len(routers) > 0 {
+		tag.Router = routers[0].Name
+	}
+	return tag.String()
+}
+
+// addressSpecs returns the A and/or AAAA records wanted for the domain, as
+// allowed by its policy.
+func (p *plan) addressSpecs(domain string) []cf.RecordSpec {
+	subject := domainSubject(domain, p.domainRouters[domain])
+	rp := resolvePolicy(subject, p.policies)
+	specs := make([]cf.RecordSpec, 0)
+	for recordType, lookedUpAddress := range p.addresses {
+		if !rp.types[recordType] {
+			continue
+		}
+		address, err := domainAddress(lookedUpAddress, subject, p.hostSuffixes)
+		if err != nil {
+			logrus.WithError(err).WithField("domain", domain).Errorln("could not determine address for domain")
+			continue
+		}
+		specs = append(specs, cf.RecordSpec{
+			Type:    recordType,
+			Name:    domain,
+			Content: address.String(),
+			Proxied: rp.proxied,
+			TTL:     rp.ttl,
+			Comment: p.comment(domain),
+		})
+	}
+	return specs
+}
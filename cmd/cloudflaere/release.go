@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/willfantom/cloudflaere/pkg/cf"
+)
+
+var (
+	releaseCmd = &cobra.Command{
+		Use:   "release",
+		Short: "stop managing the records owned by this instance, leaving them in place, then exit",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if err := release(dryRun); err != nil {
+				logrus.WithError(err).Fatalln("could not release records")
+			}
+		},
+	}
+)
+
+func init() {
+	releaseCmd.Flags().Bool("dry-run", false, "only list the records that would be released")
+	rootCmd.AddCommand(releaseCmd)
+}
+
+// ownedZone holds the records of a zone that are owned by an instance.
+type ownedZone struct {
+	zoneID  string
+	own     ownership
+	records []*cf.Record
+}
+
+// ownedRecords finds the records owned by the instance in every zone.
+func ownedRecords(c *cf.Cloudflare, instance string) ([]ownedZone, error) {
+	cfZones, err := c.GetZones()
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]ownedZone, 0)
+	for _, zoneID := range cfZones {
+		records, err := c.GetRecords(zoneID)
+		if err != nil {
+			return nil, err
+		}
+		own, err := newOwnership(c, zoneID, records, owner{instance: instance}, nil)
+		if err != nil {
+			return nil, err
+		}
+		zone := ownedZone{zoneID: zoneID, own: own}
+		for _, record := range records {
+			if own.owns(record) {
+				zone.records = append(zone.records, record)
+			}
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+// release removes the tag of this instance from the comment of each of its
+// records, and gives up any other ownership of them, so that they are left as
+// they are but no longer managed by any instance.
+func release(dryRun bool) error {
+	c, err := newCloudflare()
+	if err != nil {
+		return err
+	}
+	instance := instanceName()
+	zones, err := ownedRecords(c, instance)
+	if err != nil {
+		return err
+	}
+	released := 0
+	for _, zone := range zones {
+		for _, record := range zone.records {
+			if dryRun {
+				logrus.WithField("record", record).Infoln("record would be released")
+				released++
+				continue
+			}
+			if _, err := c.UpdateRecord(zone.zoneID, record.ID, cf.RecordSpec{
+				Type:    record.Type,
+				Name:    record.Name,
+				Content: record.Address,
+				Proxied: record.Proxied,
+				TTL:     record.TTL,
+				Comment: cf.StripTag(record.Comment),
+			}); err != nil {
+				logrus.WithError(err).WithField("record", record).Errorln("could not release record")
+				continue
+			}
+			if err := zone.own.release(record); err != nil {
+				logrus.WithError(err).WithField("record", record).Errorln("could not release record")
+				continue
+			}
+			logrus.WithField("record", record).Infoln("record released")
+			released++
+		}
+	}
+	logrus.WithField("instance", instance).WithField("count", released).WithField("dry_run", dryRun).Infoln("records released")
+	return nil
+}
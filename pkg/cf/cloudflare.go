@@ -13,20 +13,24 @@ type Cloudflare struct {
 	zoneAPI *cloudflare.API
 	dnsAPI  *cloudflare.API
 
-	allowedZones ZoneFilter
+	allowedZones    ZoneFilter
+	allowedZoneIDs  ZoneFilter
+	deniedZones     ZoneFilter
+	allowedAccounts ZoneFilter
 
 	records map[string]*Record
 }
 
 // NewCloudflare creates a new Cloudflare API client for both the zone and DNS
-// API. This returns instances to the given APIs and any errors.
+// API. This returns instances to the given APIs and any errors. Any options
+// given (such as cloudflare.BaseURL) are applied to both.
 // TODO: check api keys
-func NewCloudflare(zoneKey, dnsKey string) (*Cloudflare, error) {
-	cfZone, err := cloudflare.NewWithAPIToken(zoneKey)
+func NewCloudflare(zoneKey, dnsKey string, opts ...cloudflare.Option) (*Cloudflare, error) {
+	cfZone, err := cloudflare.NewWithAPIToken(zoneKey, opts...)
 	if err != nil {
 		return nil, err
 	}
-	cfDNS, err := cloudflare.NewWithAPIToken(dnsKey)
+	cfDNS, err := cloudflare.NewWithAPIToken(dnsKey, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -38,12 +42,53 @@ func NewCloudflare(zoneKey, dnsKey string) (*Cloudflare, error) {
 	}, nil
 }
 
+// SetAllowedZones limits the zones returned by GetZones to those with the given
+// names. If no names are given, zones are not limited by name.
 func (c *Cloudflare) SetAllowedZones(names ...string) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	c.allowedZones = ZoneFilterNameIn(names...)
 }
 
+// SetAllowedZoneIDs limits the zones returned by GetZones to those with the
+// given IDs. If no IDs are given, zones are not limited by ID.
+func (c *Cloudflare) SetAllowedZoneIDs(ids ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.allowedZoneIDs = ZoneFilterIDIn(ids...)
+}
+
+// SetDeniedZones stops zones with any of the given names from being returned
+// by GetZones.
+func (c *Cloudflare) SetDeniedZones(names ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deniedZones = ZoneFilterNameNotIn(names...)
+}
+
+// SetAllowedAccounts limits the zones returned by GetZones to those that
+// belong to the accounts with the given IDs. If no IDs are given, zones are not
+// limited by account.
+func (c *Cloudflare) SetAllowedAccounts(ids ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.allowedAccounts = ZoneFilterAccountIn(ids...)
+}
+
+// zoneFilters returns the zone filters set on the client, in the order they
+// are to be applied.
+func (c *Cloudflare) zoneFilters() []ZoneFilter {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	filters := make([]ZoneFilter, 0)
+	for _, filter := range []ZoneFilter{c.allowedAccounts, c.allowedZoneIDs, c.allowedZones, c.deniedZones} {
+		if filter != nil {
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
 func (c *Cloudflare) Records() map[string]*Record {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
@@ -54,11 +99,11 @@ func (c *Cloudflare) Poll(ctx context.Context, interval time.Duration) <-chan er
 	errChan := make(chan error)
 	go func() {
 		for {
-			c.lock.Lock()
-			zones, err := c.GetZones(c.allowedZones)
+			zones, err := c.GetZones()
 			if err != nil {
 				errChan <- err
 			}
+			c.lock.Lock()
 			c.records = make(map[string]*Record)
 			for _, zone := range zones {
 				records, err := c.GetRecords(zone, RecordFilterTypeIn("A", "AAAA"))
@@ -0,0 +1,204 @@
+package cf
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// TagPrefix starts the tag in the comment of every record made by any
+	// instance of cloudflaere.
+	TagPrefix = "##cloudflaere:"
+	tagSuffix = "##"
+
+	// TagVersion is the version of the tag format written by Tag.String.
+	TagVersion = 2
+
+	// CommentLimit is the most characters a record comment can hold on the
+	// free plan.
+	CommentLimit = 100
+
+	// createdLayout is the (compact ISO 8601) format of the created field.
+	createdLayout = "20060102T150405Z"
+)
+
+// Tag is the ownership metadata cloudflaere keeps in the comment of a record.
+// Version 1 tags (`##cloudflaere:<instance>##`) only hold the instance.
+// Version 2 tags hold a set of fields, such as
+// `##cloudflaere:v=2;instance=host1;router=a@docker;created=20250102T150405Z##`.
+// The router name includes its træfik provider.
+type Tag struct {
+	Version  int
+	Instance string
+	Router   string
+	Created  time.Time
+}
+
+// String gives the tag in the current format. Fields that are not set are
+// left out, as are those that would take the tag over CommentLimit (see fit).
+func (t Tag) String() string {
+	return t.fit().format()
+}
+
+// format gives the tag in the current format, with every field that is set.
+func (t Tag) format() string {
+	fields := []string{
+		"v=" + strconv.Itoa(TagVersion),
+		"instance=" + t.Instance,
+	}
+	if t.Router != "" {
+		fields = append(fields, "router="+t.Router)
+	}
+	if !t.Created.IsZero() {
+		fields = append(fields, "created="+t.Created.UTC().Format(createdLayout))
+	}
+	return TagPrefix + strings.Join(fields, ";") + tagSuffix
+}
+
+// fit returns the tag with its optional fields dropped, when it otherwise
+// would not fit within CommentLimit: first when it was created, then the
+// router. The instance is always kept.
+func (t Tag) fit() Tag {
+	if len(t.format()) > CommentLimit {
+		t.Created = time.Time{}
+	}
+	if len(t.format()) > CommentLimit {
+		t.Router = ""
+	}
+	return t
+}
+
+// Same reports whether two tags hold the same owner and source, as they would
+// be written by String. When each record was created is not compared.
+func (t Tag) Same(other Tag) bool {
+	t, other = t.fit(), other.fit()
+	return t.Version == other.Version && t.Instance == other.Instance &&
+		t.Router == other.Router
+}
+
+// ParseTag finds the tag in a comment, of any version. The text of the
+// comment before and after the tag is returned too, so that it can be kept
+// when the tag is replaced. False is returned if the comment has no tag.
+func ParseTag(comment string) (tag Tag, before, after string, ok bool) {
+	start := strings.Index(comment, TagPrefix)
+	if start < 0 {
+		return Tag{}, comment, "", false
+	}
+	body := comment[start+len(TagPrefix):]
+	end := strings.Index(body, tagSuffix)
+	if end < 0 {
+		return Tag{}, comment, "", false
+	}
+	before, after, body = comment[:start], body[end+len(tagSuffix):], body[:end]
+	if !strings.Contains(body, "=") {
+		return Tag{Version: 1, Instance: body}, before, after, body != ""
+	}
+	for _, field := range strings.Split(body, ";") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "v":
+			tag.Version, _ = strconv.Atoi(value)
+		case "instance":
+			tag.Instance = value
+		case "router":
+			tag.Router = value
+		case "created":
+			tag.Created = parseCreated(value)
+		}
+	}
+	return tag, before, after, tag.Version > 0 && tag.Instance != ""
+}
+
+// parseCreated parses the created field of a tag, which may also be in the
+// RFC 3339 format written by earlier releases. The zero time is returned if it
+// can not be parsed.
+func parseCreated(value string) time.Time {
+	for _, layout := range []string{createdLayout, time.RFC3339} {
+		if created, err := time.Parse(layout, value); err == nil {
+			return created
+		}
+	}
+	return time.Time{}
+}
+
+// MergeComment returns the comment of a record with its tag replaced by the
+// tag in spec (the comment of a RecordSpec), keeping any text around it. The
+// time the record was created is kept from the existing tag. If the record has
+// no tag, the spec is returned as is.
+func MergeComment(comment, spec string) string {
+	specTag, _, _, ok := ParseTag(spec)
+	if !ok {
+		return spec
+	}
+	tag, before, after, ok := ParseTag(comment)
+	if !ok {
+		return spec
+	}
+	if !tag.Created.IsZero() {
+		specTag.Created = tag.Created
+	}
+	return JoinComment(before, specTag.String(), after)
+}
+
+// JoinComment returns a comment made of the tag with the given text before and
+// after it. The text is cut short (that after the tag first, then that before
+// it) so that the comment fits within CommentLimit, keeping the tag whole.
+func JoinComment(before, tag, after string) string {
+	excess := len(before) + len(tag) + len(after) - CommentLimit
+	after, excess = cutText(after, excess)
+	before, _ = cutText(before, excess)
+	return before + tag + after
+}
+
+// cutText removes at least n bytes from the end of the text (or all of it), on
+// a character boundary. The text is returned along with the bytes still to be
+// removed.
+func cutText(text string, n int) (string, int) {
+	for n > 0 && text != "" {
+		_, size := utf8.DecodeLastRuneInString(text)
+		text, n = text[:len(text)-size], n-size
+	}
+	return text, n
+}
+
+// sameComment reports whether the comment of a record matches that of its
+// spec. When the spec comment is a tag, the record comment must have a tag in
+// the current format with the same owner and source. Otherwise, the spec
+// comment only needs to be contained within the record comment.
+func sameComment(spec, comment string) bool {
+	specTag, _, _, ok := ParseTag(spec)
+	if !ok {
+		return strings.Contains(comment, spec)
+	}
+	tag, _, _, ok := ParseTag(comment)
+	return ok && tag.Same(specTag)
+}
+
+// StripTag returns the comment with its tag removed, keeping any text that
+// was around it.
+func StripTag(comment string) string {
+	_, before, after, ok := ParseTag(comment)
+	if !ok {
+		return comment
+	}
+	before, after = strings.TrimSpace(before), strings.TrimSpace(after)
+	if before != "" && after != "" {
+		return before + " " + after
+	}
+	return before + after
+}
+
+// RetagComment returns the comment with the instance in its tag replaced,
+// keeping the rest of the tag and any text that was around it. Tags of older
+// versions are upgraded to the current format. The comment is returned as it
+// is if it has no tag.
+func RetagComment(comment, instance string) string {
+	tag, before, after, ok := ParseTag(comment)
+	if !ok {
+		return comment
+	}
+	tag.Version, tag.Instance = TagVersion, instance
+	return JoinComment(before, tag.String(), after)
+}
@@ -0,0 +1,176 @@
+package cf
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTagRoundTrip(t *testing.T) {
+	created := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		tag  Tag
+		want string
+	}{
+		{
+			name: "instance only",
+			tag:  Tag{Instance: "host1"},
+			want: "##cloudflaere:v=2;instance=host1##",
+		},
+		{
+			name: "all fields",
+			tag:  Tag{Instance: "host1", Router: "whoami@docker", Created: created},
+			want: "##cloudflaere:v=2;instance=host1;router=whoami@docker;created=20250102T150405Z##",
+		},
+		{
+			name: "created dropped to fit",
+			tag:  Tag{Instance: "host1", Router: strings.Repeat("r", 50) + "@docker", Created: created},
+			want: "##cloudflaere:v=2;instance=host1;router=" + strings.Repeat("r", 50) + "@docker##",
+		},
+		{
+			name: "router dropped to fit",
+			tag:  Tag{Instance: "host1", Router: strings.Repeat("r", 90) + "@docker", Created: created},
+			want: "##cloudflaere:v=2;instance=host1##",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.tag.String()
+			if got != tt.want {
+				t.Fatalf("String() = %q, want %q", got, tt.want)
+			}
+			if len(got) > CommentLimit {
+				t.Fatalf("String() is %d characters, over the limit of %d", len(got), CommentLimit)
+			}
+			parsed, before, after, ok := ParseTag("before " + got + " after")
+			if !ok {
+				t.Fatalf("ParseTag(%q) found no tag", got)
+			}
+			if before != "before " || after != " after" {
+				t.Fatalf("ParseTag() text = %q, %q", before, after)
+			}
+			if parsed.String() != got {
+				t.Fatalf("ParseTag().String() = %q, want %q", parsed.String(), got)
+			}
+			if !parsed.Same(Tag{Version: TagVersion, Instance: tt.tag.Instance, Router: tt.tag.Router, Created: tt.tag.Created}) {
+				t.Fatalf("parsed tag %+v is not the same as %+v", parsed, tt.tag)
+			}
+		})
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    Tag
+		ok      bool
+	}{
+		{name: "none", comment: "made by hand"},
+		{name: "unterminated", comment: "##cloudflaere:host1"},
+		{name: "empty v1", comment: "##cloudflaere:##"},
+		{name: "v1", comment: "##cloudflaere:host1##", want: Tag{Version: 1, Instance: "host1"}, ok: true},
+		{
+			name:    "rfc3339 created",
+			comment: "##cloudflaere:v=2;instance=host1;router=a@docker;provider=docker;created=2025-01-02T15:04:05Z##",
+			want:    Tag{Version: 2, Instance: "host1", Router: "a@docker", Created: time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)},
+			ok:      true,
+		},
+		{name: "no instance", comment: "##cloudflaere:v=2;router=a@docker##"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, ok := ParseTag(tt.comment)
+			if ok != tt.ok {
+				t.Fatalf("ParseTag(%q) ok = %v, want %v", tt.comment, ok, tt.ok)
+			}
+			if ok && (got.Version != tt.want.Version || !got.Same(tt.want) || !got.Created.Equal(tt.want.Created)) {
+				t.Fatalf("ParseTag(%q) = %+v, want %+v", tt.comment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeComment(t *testing.T) {
+	spec := Tag{Instance: "host1", Router: "a@docker", Created: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}.String()
+	tests := []struct {
+		name    string
+		comment string
+		want    string
+	}{
+		{
+			name:    "no tag",
+			comment: "made by hand",
+			want:    spec,
+		},
+		{
+			name:    "v1 upgraded keeping text",
+			comment: "nas ##cloudflaere:host1## keep",
+			want:    "nas ##cloudflaere:v=2;instance=host1;router=a@docker;created=20250601T000000Z## keep",
+		},
+		{
+			name:    "created kept",
+			comment: "##cloudflaere:v=2;instance=host1;router=b@docker;created=20240101T000000Z##",
+			want:    "##cloudflaere:v=2;instance=host1;router=a@docker;created=20240101T000000Z##",
+		},
+		{
+			// the text around the tag is cut short to fit, rather than the tag
+			name:    "text near the limit",
+			comment: strings.Repeat("n", 60) + " ##cloudflaere:host1## " + strings.Repeat("k", 30),
+			want:    strings.Repeat("n", 25) + "##cloudflaere:v=2;instance=host1;router=a@docker;created=20250601T000000Z##",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MergeComment(tt.comment, spec)
+			if got != tt.want {
+				t.Fatalf("MergeComment() = %q, want %q", got, tt.want)
+			}
+			if len(got) > CommentLimit {
+				t.Fatalf("merged comment is %d characters, over the limit of %d", len(got), CommentLimit)
+			}
+			if !sameComment(spec, got) {
+				t.Fatalf("merged comment %q does not match spec %q", got, spec)
+			}
+		})
+	}
+}
+
+func TestJoinComment(t *testing.T) {
+	tag := Tag{Instance: "host1"}.String()
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{name: "fits", before: "nas ", after: " keep", want: "nas " + tag + " keep"},
+		{
+			name:   "after cut first",
+			before: strings.Repeat("b", 60),
+			after:  strings.Repeat("a", 20),
+			want:   strings.Repeat("b", 60) + tag + strings.Repeat("a", CommentLimit-60-len(tag)),
+		},
+		{
+			name:   "before cut",
+			before: strings.Repeat("b", 120),
+			after:  " keep",
+			want:   strings.Repeat("b", CommentLimit-len(tag)) + tag,
+		},
+		{
+			// characters are not split
+			name:   "multibyte",
+			before: strings.Repeat("b", CommentLimit-len(tag)-1) + "æ",
+			want:   strings.Repeat("b", CommentLimit-len(tag)-1) + tag,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := JoinComment(tt.before, tag, tt.after)
+			if got != tt.want {
+				t.Fatalf("JoinComment() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package cf
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// RecordSpec describes the desired state of a record.
+type RecordSpec struct {
+	Type    string
+	Name    string
+	Content string
+	Proxied bool
+	TTL     int
+	Comment string
+}
+
+// RecordDiff holds which fields of a record differ from its spec.
+type RecordDiff struct {
+	Content bool
+	Proxied bool
+	TTL     bool
+	Comment bool
+}
+
+// Diff compares a record to its spec, ignoring differences that are only in
+// representation. Addresses are compared as parsed addresses, so that the
+// compressed and expanded forms of an ipv6 address are equal, and other
+// content is compared case-insensitively without any trailing dot. TTLs are
+// not compared for proxied records, as cloudflare always sets these to
+// automatic. Comments are compared by their tags (see Tag), so that any text
+// added around the tag is kept.
+func Diff(spec RecordSpec, record *Record) RecordDiff {
+	return RecordDiff{
+		Content: !sameContent(spec.Type, spec.Content, record.Address),
+		Proxied: spec.Proxied != record.Proxied,
+		TTL:     !spec.Proxied && spec.ttl() != record.TTL,
+		Comment: !sameComment(spec.Comment, record.Comment),
+	}
+}
+
+// Changed reports whether any field differs.
+func (d RecordDiff) Changed() bool {
+	return d.Content || d.Proxied || d.TTL || d.Comment
+}
+
+// Fields returns the names of the fields that differ.
+func (d RecordDiff) Fields() []string {
+	fields := make([]string, 0)
+	if d.Content {
+		fields = append(fields, "content")
+	}
+	if d.Proxied {
+		fields = append(fields, "proxied")
+	}
+	if d.TTL {
+		fields = append(fields, "ttl")
+	}
+	if d.Comment {
+		fields = append(fields, "comment")
+	}
+	return fields
+}
+
+func (d RecordDiff) String() string {
+	if !d.Changed() {
+		return "none"
+	}
+	return strings.Join(d.Fields(), ",")
+}
+
+// ttl returns the TTL to send to cloudflare, where 1 means automatic.
+func (s RecordSpec) ttl() int {
+	if s.TTL <= 0 {
+		return 1
+	}
+	return s.TTL
+}
+
+func sameContent(recordType, a, b string) bool {
+	if recordType == "A" || recordType == "AAAA" {
+		addrA, errA := netip.ParseAddr(a)
+		addrB, errB := netip.ParseAddr(b)
+		if errA == nil && errB == nil {
+			return addrA == addrB
+		}
+	}
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
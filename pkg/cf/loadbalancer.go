@@ -0,0 +1,201 @@
+package cf
+
+import (
+	"context"
+	"maps"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+const (
+	// LoadBalancerMarker is put in the description of the pools and load
+	// balancers made by cloudflaere. These are shared by every instance, each
+	// looking after only its own origins.
+	LoadBalancerMarker = "##cloudflaere##"
+
+	poolNamePrefix = "cloudflaere_"
+)
+
+// Pool is a load balancing pool made by cloudflaere for a single hostname.
+type Pool struct {
+	ID       string
+	Hostname string
+	// Origins maps the names of the origins in the pool to their addresses.
+	Origins map[string]string
+}
+
+// LoadBalancer is a load balancer within a zone.
+type LoadBalancer struct {
+	ID      string
+	Name    string
+	Pools   []string
+	Proxied bool
+	// Managed is whether the load balancer was made by cloudflaere.
+	Managed bool
+}
+
+// PoolName returns the name of the pool made for the hostname. Pool names may
+// only have letters, numbers, hyphens and underscores, so the dots of the
+// hostname are replaced with underscores (which hostnames can not have).
+func PoolName(hostname string) string {
+	return poolNamePrefix + strings.ReplaceAll(strings.ToLower(hostname), ".", "_")
+}
+
+// GetPools returns the pools of the account made by cloudflaere.
+func (c *Cloudflare) GetPools(accountID string) ([]*Pool, error) {
+	lbPools, err := c.dnsAPI.ListLoadBalancerPools(
+		context.Background(),
+		cloudflare.AccountIdentifier(accountID),
+		cloudflare.ListLoadBalancerPoolParams{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	pools := make([]*Pool, 0)
+	for _, lbPool := range lbPools {
+		if !strings.Contains(lbPool.Description, LoadBalancerMarker) || !strings.HasPrefix(lbPool.Name, poolNamePrefix) {
+			continue
+		}
+		pool := &Pool{
+			ID:       lbPool.ID,
+			Hostname: strings.ReplaceAll(strings.TrimPrefix(lbPool.Name, poolNamePrefix), "_", "."),
+			Origins:  make(map[string]string),
+		}
+		for _, origin := range lbPool.Origins {
+			pool.Origins[origin.Name] = origin.Address
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// CreatePool creates a pool for the hostname with the given origins (a map of
+// names to addresses). If monitor is not empty, the monitor with that ID is
+// used to health check the origins.
+func (c *Cloudflare) CreatePool(accountID, hostname string, origins map[string]string, monitor string) (*Pool, error) {
+	lbPool, err := c.dnsAPI.CreateLoadBalancerPool(
+		context.Background(),
+		cloudflare.AccountIdentifier(accountID),
+		cloudflare.CreateLoadBalancerPoolParams{
+			LoadBalancerPool: cloudflare.LoadBalancerPool{
+				Name:        PoolName(hostname),
+				Description: LoadBalancerMarker + " " + hostname,
+				Enabled:     true,
+				Monitor:     monitor,
+				Origins:     poolOrigins(nil, origins),
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{ID: lbPool.ID, Hostname: hostname, Origins: maps.Clone(origins)}, nil
+}
+
+// UpdatePoolOrigins sets the origins of the pool to those of the given pool.
+// Any other settings of the pool, and those of origins whose address is
+// unchanged (such as weights), are kept.
+func (c *Cloudflare) UpdatePoolOrigins(accountID string, pool *Pool) error {
+	rc := cloudflare.AccountIdentifier(accountID)
+	lbPool, err := c.dnsAPI.GetLoadBalancerPool(context.Background(), rc, pool.ID)
+	if err != nil {
+		return err
+	}
+	lbPool.Origins = poolOrigins(lbPool.Origins, pool.Origins)
+	_, err = c.dnsAPI.UpdateLoadBalancerPool(context.Background(), rc, cloudflare.UpdateLoadBalancerPoolParams{
+		LoadBalancer: lbPool,
+	})
+	return err
+}
+
+// DeletePool deletes the pool with the given ID.
+func (c *Cloudflare) DeletePool(accountID, poolID string) error {
+	return c.dnsAPI.DeleteLoadBalancerPool(context.Background(), cloudflare.AccountIdentifier(accountID), poolID)
+}
+
+// poolOrigins builds the origins of a pool from a map of names to addresses,
+// reusing the existing origin where one has the same name and address.
+func poolOrigins(existing []cloudflare.LoadBalancerOrigin, origins map[string]string) []cloudflare.LoadBalancerOrigin {
+	lbOrigins := make([]cloudflare.LoadBalancerOrigin, 0, len(origins))
+	reused := make(map[string]bool)
+	for _, origin := range existing {
+		if address, ok := origins[origin.Name]; ok && address == origin.Address {
+			lbOrigins = append(lbOrigins, origin)
+			reused[origin.Name] = true
+		}
+	}
+	for name, address := range origins {
+		if reused[name] {
+			continue
+		}
+		lbOrigins = append(lbOrigins, cloudflare.LoadBalancerOrigin{
+			Name:    name,
+			Address: address,
+			Enabled: true,
+			Weight:  1,
+		})
+	}
+	return lbOrigins
+}
+
+// GetLoadBalancers returns the load balancers of the zone.
+func (c *Cloudflare) GetLoadBalancers(zoneID string) ([]*LoadBalancer, error) {
+	lbs, err := c.dnsAPI.ListLoadBalancers(
+		context.Background(),
+		cloudflare.ZoneIdentifier(zoneID),
+		cloudflare.ListLoadBalancerParams{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	loadBalancers := make([]*LoadBalancer, len(lbs))
+	for i, lb := range lbs {
+		loadBalancers[i] = &LoadBalancer{
+			ID:      lb.ID,
+			Name:    lb.Name,
+			Pools:   lb.DefaultPools,
+			Proxied: lb.Proxied,
+			Managed: strings.Contains(lb.Description, LoadBalancerMarker),
+		}
+	}
+	return loadBalancers, nil
+}
+
+// CreateLoadBalancer creates a load balancer for the hostname in the zone,
+// sending all traffic to the pool with the given ID.
+func (c *Cloudflare) CreateLoadBalancer(zoneID, hostname, poolID string, proxied bool, ttl int) (*LoadBalancer, error) {
+	lb, err := c.dnsAPI.CreateLoadBalancer(
+		context.Background(),
+		cloudflare.ZoneIdentifier(zoneID),
+		cloudflare.CreateLoadBalancerParams{
+			LoadBalancer: cloudflare.LoadBalancer{
+				Name:         hostname,
+				Description:  LoadBalancerMarker,
+				FallbackPool: poolID,
+				DefaultPools: []string{poolID},
+				Proxied:      proxied,
+				TTL:          loadBalancerTTL(proxied, ttl),
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &LoadBalancer{ID: lb.ID, Name: lb.Name, Pools: lb.DefaultPools, Proxied: lb.Proxied, Managed: true}, nil
+}
+
+// loadBalancerTTL returns the TTL to give a load balancer. TTLs only apply to
+// load balancers that are not proxied, and 1 (automatic) is left for the API
+// to decide, as with records.
+func loadBalancerTTL(proxied bool, ttl int) int {
+	if proxied || ttl <= 1 {
+		return 0
+	}
+	return ttl
+}
+
+// DeleteLoadBalancer deletes the load balancer with the given ID from the zone.
+func (c *Cloudflare) DeleteLoadBalancer(zoneID, id string) error {
+	return c.dnsAPI.DeleteLoadBalancer(context.Background(), cloudflare.ZoneIdentifier(zoneID), id)
+}
@@ -0,0 +1,84 @@
+package cf
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestPoolName(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     string
+	}{
+		{hostname: "a.example.com", want: "cloudflaere_a_example_com"},
+		{hostname: "My-App.Example.com", want: "cloudflaere_my-app_example_com"},
+		{hostname: "example.com", want: "cloudflaere_example_com"},
+	}
+	for _, tt := range tests {
+		if got := PoolName(tt.hostname); got != tt.want {
+			t.Fatalf("PoolName(%s) = %s, want %s", tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestGetPools(t *testing.T) {
+	pools := []cloudflare.LoadBalancerPool{
+		{
+			ID:          "p1",
+			Name:        PoolName("a.example.com"),
+			Description: LoadBalancerMarker + " a.example.com",
+			Origins: []cloudflare.LoadBalancerOrigin{
+				{Name: "host1", Address: "198.51.100.1", Enabled: true},
+				{Name: "host1-v6", Address: "2001:db8::1", Enabled: true},
+			},
+		},
+		{ID: "p2", Name: PoolName("my-app.dev.example.com"), Description: LoadBalancerMarker + " my-app.dev.example.com"},
+		// made by hand, though named like ours
+		{ID: "p3", Name: PoolName("b.example.com"), Description: "b.example.com"},
+		// marked, but not named like ours
+		{ID: "p4", Name: "c_example_com", Description: LoadBalancerMarker + " c.example.com"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/accounts/acc/load_balancers/pools" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"success":  true,
+			"errors":   []any{},
+			"messages": []any{},
+			"result":   pools,
+		})
+	}))
+	defer server.Close()
+	c, err := NewCloudflare("token", "token", cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetPools("acc")
+	if err != nil {
+		t.Fatalf("GetPools() error = %v", err)
+	}
+	want := map[string]string{"p1": "a.example.com", "p2": "my-app.dev.example.com"}
+	if len(got) != len(want) {
+		t.Fatalf("GetPools() returned %d pools, want %d", len(got), len(want))
+	}
+	for _, pool := range got {
+		if pool.Hostname != want[pool.ID] {
+			t.Fatalf("GetPools() pool %s hostname = %q, want %q", pool.ID, pool.Hostname, want[pool.ID])
+		}
+	}
+	origins := map[string]string{"host1": "198.51.100.1", "host1-v6": "2001:db8::1"}
+	for _, pool := range got {
+		if pool.ID == "p1" && !maps.Equal(pool.Origins, origins) {
+			t.Fatalf("GetPools() pool p1 origins = %v, want %v", pool.Origins, origins)
+		}
+	}
+}
@@ -15,16 +15,18 @@ type Record struct {
 	Address string
 	Comment string
 	Proxied bool
+	TTL     int
 }
 
-func newRecord(id, t, name, address, comment string, proxied bool) *Record {
+func newRecord(r cloudflare.DNSRecord) *Record {
 	return &Record{
-		ID:      id,
-		Type:    t,
-		Name:    name,
-		Address: address,
-		Comment: comment,
-		Proxied: proxied,
+		ID:      r.ID,
+		Type:    r.Type,
+		Name:    r.Name,
+		Address: r.Content,
+		Comment: r.Comment,
+		Proxied: r.Proxied != nil && *r.Proxied,
+		TTL:     r.TTL,
 	}
 }
 
@@ -41,7 +43,7 @@ func (c *Cloudflare) GetRecords(zoneID string, filters ...RecordFilter) ([]*Reco
 	}
 	filteredRecords := make([]*Record, len(records))
 	for i, record := range records {
-		filteredRecords[i] = newRecord(record.ID, record.Type, record.Name, record.Content, record.Comment, *record.Proxied)
+		filteredRecords[i] = newRecord(record)
 	}
 	return c.FilterRecords(filteredRecords, filters...), nil
 }
@@ -55,23 +57,57 @@ func (c *Cloudflare) FilterRecords(records []*Record, filters ...RecordFilter) [
 }
 
 func (c *Cloudflare) AddRecord(zoneID string, t, name, content, comment string, proxied bool) (*Record, error) {
+	return c.CreateRecord(zoneID, RecordSpec{
+		Type:    t,
+		Name:    name,
+		Content: content,
+		Comment: comment,
+		Proxied: proxied,
+	})
+}
+
+// CreateRecord creates a new record in the zone as described by the spec. A
+// TTL of 0 is sent as 1 (automatic).
+func (c *Cloudflare) CreateRecord(zoneID string, spec RecordSpec) (*Record, error) {
 	if r, err := c.dnsAPI.CreateDNSRecord(
 		context.Background(),
 		cloudflare.ZoneIdentifier(zoneID),
 		cloudflare.CreateDNSRecordParams{
-			Type:    t,
-			Name:    name,
-			Content: content,
-			Proxied: cloudflare.BoolPtr(proxied),
-			Comment: comment,
+			Type:    spec.Type,
+			Name:    spec.Name,
+			Content: spec.Content,
+			Proxied: cloudflare.BoolPtr(spec.Proxied),
+			TTL:     spec.ttl(),
+			Comment: spec.Comment,
 		},
 	); err != nil {
 		return nil, err
 	} else {
-		return newRecord(r.ID, r.Type, r.Name, r.Content, r.Comment, *r.Proxied), nil
+		return newRecord(r), nil
 	}
 }
 
+// UpdateRecord sets every field of an existing record to match the spec.
+func (c *Cloudflare) UpdateRecord(zoneID, id string, spec RecordSpec) (*Record, error) {
+	r, err := c.dnsAPI.UpdateDNSRecord(
+		context.Background(),
+		cloudflare.ZoneIdentifier(zoneID),
+		cloudflare.UpdateDNSRecordParams{
+			ID:      id,
+			Type:    spec.Type,
+			Name:    spec.Name,
+			Content: spec.Content,
+			Proxied: cloudflare.BoolPtr(spec.Proxied),
+			TTL:     spec.ttl(),
+			Comment: cloudflare.StringPtr(spec.Comment),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return newRecord(r), nil
+}
+
 func (c *Cloudflare) UpdateRecordAddress(zoneID string, id, address string) error {
 	_, err := c.dnsAPI.UpdateDNSRecord(
 		context.Background(),
@@ -109,6 +145,10 @@ func RecordFilterTypeIn(types ...string) RecordFilter {
 	}
 }
 
+// RecordFilterNameIn returns a record filter that filters records based on the
+// given names, compared case-insensitively and ignoring any trailing dot. If a
+// record has a name that is **not** in the given list, it will be filtered out
+// of any returned set.
 func RecordFilterNameIn(names ...string) RecordFilter {
 	return func(records []*Record) []*Record {
 		if len(names) == 0 {
@@ -117,7 +157,7 @@ func RecordFilterNameIn(names ...string) RecordFilter {
 		filteredRecords := make([]*Record, 0)
 		for _, record := range records {
 			for _, n := range names {
-				if record.Name == n {
+				if SameName(record.Name, n) {
 					filteredRecords = append(filteredRecords, record)
 				}
 			}
@@ -126,6 +166,12 @@ func RecordFilterNameIn(names ...string) RecordFilter {
 	}
 }
 
+// SameName reports whether two record names are the same, ignoring case and
+// any trailing dot.
+func SameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
 func RecordFilterAddressIn(addresses ...netip.Addr) RecordFilter {
 	return func(records []*Record) []*Record {
 		if len(addresses) == 0 {
@@ -0,0 +1,74 @@
+package cf
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// RegistryPrefix is put before the name of a record to give the name of the
+	// TXT records holding its ownership.
+	RegistryPrefix = "_cloudflaere."
+
+	registryHeritage = "cloudflaere"
+)
+
+// RegistryEntry records which instance owns a record, in the content of a TXT
+// record named after it (see RegistryName). Unlike a comment, this is not
+// easily changed by accident on the dashboard.
+type RegistryEntry struct {
+	Instance string
+	Type     string
+	RecordID string
+	Router   string
+}
+
+// RegistryName returns the name of the TXT records holding the registry
+// entries of records with the given name.
+func RegistryName(name string) string {
+	return RegistryPrefix + strings.TrimSuffix(name, ".")
+}
+
+// IsRegistryName reports whether the name is that of a registry TXT record.
+func IsRegistryName(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), RegistryPrefix)
+}
+
+// String gives the entry as the content of a TXT record, in the form
+// "heritage=cloudflaere,owner=<instance>,type=<type>,record=<id>,router=<router>".
+func (e RegistryEntry) String() string {
+	fields := []string{
+		"heritage=" + registryHeritage,
+		"owner=" + e.Instance,
+		"type=" + e.Type,
+		"record=" + e.RecordID,
+	}
+	if e.Router != "" {
+		fields = append(fields, "router="+e.Router)
+	}
+	return `"` + strings.Join(fields, ",") + `"`
+}
+
+// ParseRegistryEntry reads a registry entry from the content of a TXT record.
+// An error is returned if the content is not a cloudflaere registry entry.
+func ParseRegistryEntry(content string) (RegistryEntry, error) {
+	content = strings.Trim(strings.TrimSpace(content), `"`)
+	fields := make(map[string]string)
+	for _, field := range strings.Split(content, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if fields["heritage"] != registryHeritage {
+		return RegistryEntry{}, fmt.Errorf("not a cloudflaere registry entry")
+	}
+	entry := RegistryEntry{
+		Instance: fields["owner"],
+		Type:     fields["type"],
+		RecordID: fields["record"],
+		Router:   fields["router"],
+	}
+	if entry.Instance == "" || entry.RecordID == "" {
+		return RegistryEntry{}, fmt.Errorf("registry entry has no owner or record")
+	}
+	return entry, nil
+}
@@ -0,0 +1,120 @@
+package cf
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// catchAllService is the service given to the catch-all ingress rule when the
+// tunnel does not already have one. Cloudflare requires the last ingress rule
+// to match any hostname.
+const catchAllService = "http_status:404"
+
+// TunnelTarget returns the hostname that records must be a (proxied) CNAME to
+// for requests to be routed through the tunnel with the given ID.
+func TunnelTarget(tunnelID string) string {
+	return tunnelID + ".cfargotunnel.com"
+}
+
+// SyncTunnelIngress makes the remote ingress config of a tunnel route each of
+// the given hostnames to service. Ingress rules routing one of the hostnames,
+// or one of the managed hostnames (such as those synced before), to service
+// are treated as owned: those for hostnames no longer given are removed. All
+// other rules, including any made by hand that also route to service and the
+// catch-all, are left as they are. As rules are matched in order, the owned
+// rules are placed ahead of any wildcard rule that would otherwise route one
+// of the hostnames elsewhere. Hostnames still routed elsewhere by an earlier
+// rule (one made by hand for the same hostname) are returned as shadowed. The
+// config is only written when it changes, in which case true is returned.
+func (c *Cloudflare) SyncTunnelIngress(accountID, tunnelID, service string, hostnames, managed []string) (bool, []string, error) {
+	rc := cloudflare.AccountIdentifier(accountID)
+	current, err := c.dnsAPI.GetTunnelConfiguration(context.Background(), rc, tunnelID)
+	if err != nil {
+		return false, nil, err
+	}
+	owned := make(map[string]bool)
+	for _, hostname := range append(slices.Clone(hostnames), managed...) {
+		owned[strings.ToLower(strings.TrimSuffix(hostname, "."))] = true
+	}
+	sorted := make([]string, len(hostnames))
+	for i, hostname := range hostnames {
+		sorted[i] = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	}
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	kept := make([]cloudflare.UnvalidatedIngressRule, 0)
+	var catchAll *cloudflare.UnvalidatedIngressRule
+	for _, rule := range current.Config.Ingress {
+		switch {
+		case rule.Hostname == "" && rule.Path == "":
+			catchAll = &rule
+		case rule.Service == service && rule.Path == "" && owned[strings.ToLower(rule.Hostname)]:
+			// owned rules are added back below, in order
+		default:
+			kept = append(kept, rule)
+		}
+	}
+	at := slices.IndexFunc(kept, func(rule cloudflare.UnvalidatedIngressRule) bool {
+		return strings.HasPrefix(rule.Hostname, "*") && slices.ContainsFunc(sorted, func(hostname string) bool {
+			return ingressShadows(rule, hostname)
+		})
+	})
+	if at < 0 {
+		at = len(kept)
+	}
+	shadowed := make([]string, 0)
+	ingress := slices.Clone(kept[:at])
+	for _, hostname := range sorted {
+		if slices.ContainsFunc(ingress, func(rule cloudflare.UnvalidatedIngressRule) bool {
+			return ingressShadows(rule, hostname)
+		}) {
+			shadowed = append(shadowed, hostname)
+		}
+		ingress = append(ingress, cloudflare.UnvalidatedIngressRule{
+			Hostname: hostname,
+			Service:  service,
+		})
+	}
+	ingress = append(ingress, kept[at:]...)
+	if catchAll == nil {
+		catchAll = &cloudflare.UnvalidatedIngressRule{Service: catchAllService}
+	}
+	ingress = append(ingress, *catchAll)
+	if ingressEqual(current.Config.Ingress, ingress) {
+		return false, shadowed, nil
+	}
+	config := current.Config
+	config.Ingress = ingress
+	_, err = c.dnsAPI.UpdateTunnelConfiguration(context.Background(), rc, cloudflare.TunnelConfigurationParams{
+		TunnelID: tunnelID,
+		Config:   config,
+	})
+	return err == nil, shadowed, err
+}
+
+// ingressShadows reports whether the rule matches every request for the
+// hostname, so that no later rule for it is used. Rules with a path only match
+// some requests, and a wildcard (`*.example.com`) matches any hostname ending
+// in the rest of it.
+func ingressShadows(rule cloudflare.UnvalidatedIngressRule, hostname string) bool {
+	if rule.Path != "" || rule.Hostname == "" {
+		return false
+	}
+	pattern := strings.ToLower(rule.Hostname)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(hostname, suffix)
+	}
+	return pattern == hostname
+}
+
+// ingressEqual reports whether two sets of ingress rules route the same
+// hostnames and paths to the same services, in the same order.
+func ingressEqual(a, b []cloudflare.UnvalidatedIngressRule) bool {
+	return slices.EqualFunc(a, b, func(x, y cloudflare.UnvalidatedIngressRule) bool {
+		return strings.EqualFold(x.Hostname, y.Hostname) && x.Path == y.Path && x.Service == y.Service
+	})
+}
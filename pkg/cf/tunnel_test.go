@@ -0,0 +1,110 @@
+package cf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// rule is shorthand for an ingress rule.
+func rule(hostname, path, service string) cloudflare.UnvalidatedIngressRule {
+	return cloudflare.UnvalidatedIngressRule{Hostname: hostname, Path: path, Service: service}
+}
+
+func TestSyncTunnelIngress(t *testing.T) {
+	const service = "http://traefik:80"
+	catchAll := rule("", "", catchAllService)
+	tests := []struct {
+		name         string
+		current      []cloudflare.UnvalidatedIngressRule
+		hostnames    []string
+		managed      []string
+		want         []cloudflare.UnvalidatedIngressRule
+		wantShadowed []string
+	}{
+		{
+			name:      "catch-all added",
+			hostnames: []string{"b.example.com", "A.example.com."},
+			want:      []cloudflare.UnvalidatedIngressRule{rule("a.example.com", "", service), rule("b.example.com", "", service), catchAll},
+		},
+		{
+			name:      "hand-made rules kept in front",
+			current:   []cloudflare.UnvalidatedIngressRule{rule("x.other.org", "", "http://x"), rule("a.example.com", "/api", "http://api"), rule("", "", "http_status:503")},
+			hostnames: []string{"a.example.com"},
+			want:      []cloudflare.UnvalidatedIngressRule{rule("x.other.org", "", "http://x"), rule("a.example.com", "/api", "http://api"), rule("a.example.com", "", service), rule("", "", "http_status:503")},
+		},
+		{
+			// rules after a wildcard matching them would never be used
+			name:      "ahead of wildcard",
+			current:   []cloudflare.UnvalidatedIngressRule{rule("*.other.org", "", "http://x"), rule("*.example.com", "", "http://wild"), catchAll},
+			hostnames: []string{"a.example.com"},
+			want:      []cloudflare.UnvalidatedIngressRule{rule("*.other.org", "", "http://x"), rule("a.example.com", "", service), rule("*.example.com", "", "http://wild"), catchAll},
+		},
+		{
+			name:      "owned rules replaced",
+			current:   []cloudflare.UnvalidatedIngressRule{rule("*.example.com", "", service), rule("old.example.com", "", service), rule("hand.example.com", "", service), catchAll},
+			hostnames: []string{"a.example.com"},
+			managed:   []string{"old.example.com"},
+			want:      []cloudflare.UnvalidatedIngressRule{rule("a.example.com", "", service), rule("*.example.com", "", service), rule("hand.example.com", "", service), catchAll},
+		},
+		{
+			name:         "shadowed by hand-made rule",
+			current:      []cloudflare.UnvalidatedIngressRule{rule("a.example.com", "", "http://elsewhere"), catchAll},
+			hostnames:    []string{"a.example.com", "b.example.com"},
+			want:         []cloudflare.UnvalidatedIngressRule{rule("a.example.com", "", "http://elsewhere"), rule("a.example.com", "", service), rule("b.example.com", "", service), catchAll},
+			wantShadowed: []string{"a.example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingress := tt.current
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/accounts/acc/cfd_tunnel/tid/configurations" {
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+					http.NotFound(w, r)
+					return
+				}
+				if r.Method == http.MethodPut {
+					var params cloudflare.TunnelConfigurationParams
+					json.NewDecoder(r.Body).Decode(&params)
+					ingress = params.Config.Ingress
+				}
+				json.NewEncoder(w).Encode(map[string]any{
+					"success":  true,
+					"errors":   []any{},
+					"messages": []any{},
+					"result": cloudflare.TunnelConfigurationResult{
+						TunnelID: "tid",
+						Config:   cloudflare.TunnelConfiguration{Ingress: ingress},
+					},
+				})
+			}))
+			defer server.Close()
+			c, err := NewCloudflare("token", "token", cloudflare.BaseURL(server.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, shadowed, err := c.SyncTunnelIngress("acc", "tid", service, tt.hostnames, tt.managed)
+			if err != nil {
+				t.Fatalf("SyncTunnelIngress() error = %v", err)
+			}
+			if !ingressEqual(ingress, tt.want) {
+				t.Fatalf("ingress = %v, want %v", ingress, tt.want)
+			}
+			if !slices.Equal(shadowed, tt.wantShadowed) && len(shadowed)+len(tt.wantShadowed) > 0 {
+				t.Fatalf("shadowed = %v, want %v", shadowed, tt.wantShadowed)
+			}
+
+			// a second sync changes nothing
+			changed, _, err := c.SyncTunnelIngress("acc", "tid", service, tt.hostnames, tt.managed)
+			if err != nil || changed {
+				t.Fatalf("second SyncTunnelIngress() = %v, %v, want no change", changed, err)
+			}
+		})
+	}
+}
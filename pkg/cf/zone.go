@@ -2,6 +2,7 @@ package cf
 
 import (
 	"context"
+	"strings"
 
 	"github.com/cloudflare/cloudflare-go"
 )
@@ -18,14 +19,15 @@ type ZoneFilter func(zone []cloudflare.Zone) []cloudflare.Zone
 
 // GetZones returns a map of zone names to zone IDs. This is useful for
 // interacting with the Cloudflare API since many requests (such as DNS
-// requests) require a zone ID. Zones can be filtered too based on a given set
+// requests) require a zone ID. Zones are first filtered by any allow and deny
+// lists set on the client, and can then be filtered too based on a given set
 // of filters that are ran in the order provided.
 func (c *Cloudflare) GetZones(filters ...ZoneFilter) (map[string]string, error) {
 	zones, err := c.zoneAPI.ListZones(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	for _, filter := range filters {
+	for _, filter := range append(c.zoneFilters(), filters...) {
 		zones = filter(zones)
 	}
 	zoneMap := make(map[string]string)
@@ -35,6 +37,27 @@ func (c *Cloudflare) GetZones(filters ...ZoneFilter) (map[string]string, error)
 	return zoneMap, nil
 }
 
+// ZoneFor finds the zone that a hostname belongs in, given a map of zone names
+// to zone IDs (as returned by GetZones). This is the zone with the longest name
+// that is either the hostname itself or a parent domain of it, so that
+// delegated subzones (e.g. `dev.example.com` within `example.com`) are matched
+// correctly. The name and ID of the zone are returned, or false if no zone
+// matches.
+func ZoneFor(zones map[string]string, hostname string) (string, string, bool) {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	bestName, bestID := "", ""
+	for name, id := range zones {
+		zoneName := strings.ToLower(strings.TrimSuffix(name, "."))
+		if hostname != zoneName && !strings.HasSuffix(hostname, "."+zoneName) {
+			continue
+		}
+		if len(zoneName) > len(bestName) {
+			bestName, bestID = name, id
+		}
+	}
+	return bestName, bestID, bestID != ""
+}
+
 // ZoneFilterNameIn returns a zone filter that filters zones based on the given
 // names. If a zone has a name that is **not** in the given list, it will be
 // filtered out of any returned set.
@@ -74,3 +97,45 @@ func ZoneFilterIDIn(ids ...string) ZoneFilter {
 		return filteredZones
 	}
 }
+
+// ZoneFilterNameNotIn returns a zone filter that filters zones based on the
+// given names. If a zone has a name that **is** in the given list, it will be
+// filtered out of any returned set.
+func ZoneFilterNameNotIn(names ...string) ZoneFilter {
+	return func(zones []cloudflare.Zone) []cloudflare.Zone {
+		filteredZones := make([]cloudflare.Zone, 0)
+		for _, zone := range zones {
+			denied := false
+			for _, name := range names {
+				if zone.Name == name {
+					denied = true
+					break
+				}
+			}
+			if !denied {
+				filteredZones = append(filteredZones, zone)
+			}
+		}
+		return filteredZones
+	}
+}
+
+// ZoneFilterAccountIn returns a zone filter that filters zones based on the
+// given account ids. If a zone belongs to an account that is **not** in the
+// given list, it will be filtered out of any returned set.
+func ZoneFilterAccountIn(ids ...string) ZoneFilter {
+	return func(zones []cloudflare.Zone) []cloudflare.Zone {
+		if len(ids) == 0 {
+			return zones
+		}
+		filteredZones := make([]cloudflare.Zone, 0)
+		for _, zone := range zones {
+			for _, id := range ids {
+				if zone.Account.ID == id {
+					filteredZones = append(filteredZones, zone)
+				}
+			}
+		}
+		return filteredZones
+	}
+}
@@ -0,0 +1,166 @@
+package dyndns
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// UpdatePath is the path of the dyndns2 update endpoint.
+const UpdatePath = "/nic/update"
+
+// Server implements the server side of the dyndns2 update protocol, so that
+// routers can push their current address rather than it being looked up. Only
+// the most recent ipv4 and ipv6 addresses pushed are kept.
+type Server struct {
+	lock      *sync.RWMutex
+	username  string
+	password  string
+	hostnames []string
+
+	addresses map[bool]netip.Addr
+	updates   chan struct{}
+}
+
+// NewServer creates a dyndns2 server that accepts updates authenticated with
+// the given basic auth credentials. If any hostnames are given, updates for
+// other hostnames are rejected.
+func NewServer(username, password string, hostnames ...string) *Server {
+	return &Server{
+		lock:      &sync.RWMutex{},
+		username:  username,
+		password:  password,
+		hostnames: hostnames,
+		addresses: make(map[bool]netip.Addr),
+		updates:   make(chan struct{}, 1),
+	}
+}
+
+// Updates signals whenever a pushed address differs from the one held. Bursts
+// of updates are coalesced into a single signal.
+func (s *Server) Updates() <-chan struct{} {
+	return s.updates
+}
+
+// LookupIP returns the last address of the given family that was pushed.
+func (s *Server) LookupIP(ipv6 bool) (netip.Addr, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	addr, ok := s.addresses[ipv6]
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("no address has been pushed yet")
+	}
+	return addr, nil
+}
+
+// ServeHTTP handles `/nic/update?hostname=<hostnames>&myip=<addresses>`
+// requests. Both hostname and myip may be comma separated lists, with myip
+// holding up to one address of each family. The ipv6 address may also be given
+// as myipv6. If no address is given, the address the request came from is
+// used. Responses are the plain text return codes of the protocol (e.g. `good
+// 192.0.2.1`, `nochg 192.0.2.1`, `badauth`).
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != UpdatePath {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="cloudflaere"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+	hostnames := splitList(r.URL.Query().Get("hostname"))
+	if len(hostnames) == 0 {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+	for _, hostname := range hostnames {
+		if !s.allowedHostname(hostname) {
+			fmt.Fprintln(w, "nohost")
+			return
+		}
+	}
+	addrStrs := append(splitList(r.URL.Query().Get("myip")), splitList(r.URL.Query().Get("myipv6"))...)
+	if len(addrStrs) == 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err == nil {
+			addrStrs = append(addrStrs, host)
+		}
+	}
+	addrs := make([]netip.Addr, 0)
+	for _, addrStr := range addrStrs {
+		addr, err := netip.ParseAddr(addrStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "911")
+			return
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+	changed := s.set(addrs...)
+	code := "nochg"
+	if changed {
+		code = "good"
+		select {
+		case s.updates <- struct{}{}:
+		default:
+		}
+	}
+	addrStrs = make([]string, len(addrs))
+	for i, addr := range addrs {
+		addrStrs[i] = addr.String()
+	}
+	fmt.Fprintln(w, code, strings.Join(addrStrs, ","))
+}
+
+// set stores the given addresses, returning true if any differed from those
+// already held.
+func (s *Server) set(addrs ...netip.Addr) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	changed := false
+	for _, addr := range addrs {
+		if current, ok := s.addresses[addr.Is6()]; !ok || current != addr {
+			s.addresses[addr.Is6()] = addr
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+	return usernameOK && passwordOK
+}
+
+func (s *Server) allowedHostname(hostname string) bool {
+	if len(s.hostnames) == 0 {
+		return true
+	}
+	for _, h := range s.hostnames {
+		if strings.EqualFold(strings.TrimSuffix(hostname, "."), strings.TrimSuffix(h, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
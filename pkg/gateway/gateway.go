@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// DefaultTimeout is how long to wait for the gateway to answer a request.
+var DefaultTimeout = 3 * time.Second
+
+// Protocol is a protocol that can be used to ask the local gateway for its
+// external address.
+type Protocol string
+
+const (
+	ProtocolAuto   Protocol = "auto"
+	ProtocolUPnP   Protocol = "upnp"
+	ProtocolNATPMP Protocol = "natpmp"
+	ProtocolPCP    Protocol = "pcp"
+)
+
+// LookupIP asks the gateway for its external (WAN) ipv4 address using the
+// given protocol. With ProtocolAuto, NAT-PMP, PCP and UPnP IGD are tried in
+// that order. If gateway is not valid, the default gateway of this host is
+// used for NAT-PMP and PCP. If location is empty, the UPnP device description
+// is found with SSDP discovery.
+func LookupIP(protocol Protocol, gateway netip.Addr, location string) (netip.Addr, error) {
+	if !gateway.IsValid() && protocol != ProtocolUPnP {
+		gw, err := DefaultGateway()
+		if err != nil && protocol != ProtocolAuto {
+			return netip.Addr{}, fmt.Errorf("could not find default gateway: %w", err)
+		}
+		gateway = gw
+	}
+	switch protocol {
+	case ProtocolNATPMP:
+		return LookupNATPMP(gateway)
+	case ProtocolPCP:
+		return LookupPCP(gateway)
+	case ProtocolUPnP:
+		return LookupUPnP(location)
+	case ProtocolAuto, "":
+		errs := make([]error, 0)
+		if gateway.IsValid() {
+			if addr, err := LookupNATPMP(gateway); err == nil {
+				return addr, nil
+			} else {
+				errs = append(errs, fmt.Errorf("nat-pmp: %w", err))
+			}
+			if addr, err := LookupPCP(gateway); err == nil {
+				return addr, nil
+			} else {
+				errs = append(errs, fmt.Errorf("pcp: %w", err))
+			}
+		}
+		if addr, err := LookupUPnP(location); err == nil {
+			return addr, nil
+		} else {
+			errs = append(errs, fmt.Errorf("upnp: %w", err))
+		}
+		return netip.Addr{}, errors.Join(errs...)
+	default:
+		return netip.Addr{}, fmt.Errorf("unknown gateway protocol: %s", protocol)
+	}
+}
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// natpmpPort is the port NAT-PMP and PCP gateways listen on.
+var natpmpPort = 5351
+
+const (
+	natpmpVersion = 0
+
+	natpmpOpExternalAddress = 0
+	natpmpOpResponse        = 128
+)
+
+// LookupNATPMP asks the gateway for its external address with a NAT-PMP (RFC
+// 6886) external address request.
+func LookupNATPMP(gateway netip.Addr) (netip.Addr, error) {
+	resp, err := exchange(gateway, []byte{natpmpVersion, natpmpOpExternalAddress}, func(resp []byte) bool {
+		return len(resp) >= 2 && resp[0] == natpmpVersion && resp[1] == natpmpOpResponse|natpmpOpExternalAddress
+	})
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if len(resp) < 12 {
+		return netip.Addr{}, fmt.Errorf("response too short")
+	}
+	if result := binary.BigEndian.Uint16(resp[2:4]); result != 0 {
+		return netip.Addr{}, fmt.Errorf("gateway returned result code %d", result)
+	}
+	return netip.AddrFrom4([4]byte(resp[8:12])), nil
+}
+
+// exchange sends the request to the NAT-PMP/PCP port of the gateway and waits
+// for a response that the given function accepts. Requests are retried with an
+// increasing delay, as described in RFC 6886, until DefaultTimeout passes.
+func exchange(gateway netip.Addr, request []byte, accept func(resp []byte) bool) ([]byte, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(gateway.String(), strconv.Itoa(natpmpPort)))
+	if err != nil {
+		return nil, fmt.Errorf("could not dial gateway: %w", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(DefaultTimeout)
+	buf := make([]byte, 1100)
+	for wait := 250 * time.Millisecond; time.Now().Before(deadline); wait *= 2 {
+		if _, err := conn.Write(request); err != nil {
+			return nil, fmt.Errorf("could not send request: %w", err)
+		}
+		attemptDeadline := time.Now().Add(wait)
+		if attemptDeadline.After(deadline) {
+			attemptDeadline = deadline
+		}
+		conn.SetReadDeadline(attemptDeadline)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if accept(buf[:n]) {
+				return buf[:n], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response from gateway %s", gateway)
+}
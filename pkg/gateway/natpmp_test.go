@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubGateway runs a NAT-PMP/PCP gateway on the loopback address, answering
+// each request with the responses returned by respond. The port the lookups
+// use is pointed at it for the duration of the test.
+func stubGateway(t *testing.T, respond func(request []byte) [][]byte) netip.Addr {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	port, timeout := natpmpPort, DefaultTimeout
+	natpmpPort, DefaultTimeout = conn.LocalAddr().(*net.UDPAddr).Port, time.Second
+	t.Cleanup(func() { natpmpPort, DefaultTimeout = port, timeout })
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, resp := range respond(append([]byte{}, buf[:n]...)) {
+				conn.WriteTo(resp, from)
+			}
+		}
+	}()
+	return netip.MustParseAddr("127.0.0.1")
+}
+
+// natpmpResponse builds a NAT-PMP external address response.
+func natpmpResponse(result uint16, addr netip.Addr) []byte {
+	resp := make([]byte, 12)
+	resp[1] = natpmpOpResponse | natpmpOpExternalAddress
+	binary.BigEndian.PutUint16(resp[2:4], result)
+	binary.BigEndian.PutUint32(resp[4:8], 1234)
+	a := addr.As4()
+	copy(resp[8:12], a[:])
+	return resp
+}
+
+func TestLookupNATPMP(t *testing.T) {
+	external := netip.MustParseAddr("198.51.100.4")
+	tests := []struct {
+		name    string
+		respond func(request []byte) [][]byte
+		want    netip.Addr
+		wantErr string
+	}{
+		{
+			name: "address",
+			respond: func(request []byte) [][]byte {
+				if len(request) != 2 || request[0] != natpmpVersion || request[1] != natpmpOpExternalAddress {
+					return nil
+				}
+				return [][]byte{natpmpResponse(0, external)}
+			},
+			want: external,
+		},
+		{
+			name: "other responses ignored",
+			respond: func(request []byte) [][]byte {
+				other := natpmpResponse(0, netip.MustParseAddr("192.0.2.1"))
+				other[1] = natpmpOpResponse | 1
+				return [][]byte{other, natpmpResponse(0, external)}
+			},
+			want: external,
+		},
+		{
+			name: "result code",
+			respond: func(request []byte) [][]byte {
+				return [][]byte{natpmpResponse(3, netip.AddrFrom4([4]byte{}))}
+			},
+			wantErr: "result code 3",
+		},
+		{
+			name: "too short",
+			respond: func(request []byte) [][]byte {
+				return [][]byte{natpmpResponse(0, external)[:8]}
+			},
+			wantErr: "response too short",
+		},
+		{
+			name:    "no response",
+			respond: func(request []byte) [][]byte { return nil },
+			wantErr: "no response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := stubGateway(t, tt.respond)
+			got, err := LookupNATPMP(gateway)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LookupNATPMP() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LookupNATPMP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("LookupNATPMP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	pcpVersion     = 2
+	pcpOpMap       = 1
+	pcpOpResponse  = 0x80
+	pcpProtocolUDP = 17
+
+	pcpHeaderLength = 24
+	pcpMapLength    = 36
+
+	// pcpMapLifetime is the lifetime requested for the mapping that is used to
+	// learn the external address. It is deleted straight after anyway.
+	pcpMapLifetime = 120
+)
+
+// LookupPCP asks the gateway for its external address using PCP (RFC 6887).
+// PCP has no request for just the external address, so a short lived MAP of
+// an unused local udp port is requested, the assigned external address is read
+// from the response, and the mapping is then deleted.
+func LookupPCP(gateway netip.Addr) (netip.Addr, error) {
+	// a local udp socket is bound only to reserve a port number to map
+	local, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not reserve local port: %w", err)
+	}
+	defer local.Close()
+	internalPort := uint16(local.LocalAddr().(*net.UDPAddr).Port)
+
+	clientAddr, err := localAddress(gateway)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return netip.Addr{}, err
+	}
+
+	resp, err := exchange(gateway, newPCPMapRequest(clientAddr, nonce, internalPort, pcpMapLifetime), acceptPCPMap(nonce))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if result := resp[3]; result != 0 {
+		return netip.Addr{}, fmt.Errorf("gateway returned result code %d", result)
+	}
+	external := netip.AddrFrom16([16]byte(resp[pcpHeaderLength+20 : pcpHeaderLength+36])).Unmap()
+
+	// best effort removal of the mapping
+	exchange(gateway, newPCPMapRequest(clientAddr, nonce, internalPort, 0), acceptPCPMap(nonce))
+
+	if !external.Is4() {
+		return netip.Addr{}, fmt.Errorf("gateway assigned non ipv4 address %s", external)
+	}
+	return external, nil
+}
+
+func newPCPMapRequest(client netip.Addr, nonce []byte, internalPort uint16, lifetime uint32) []byte {
+	msg := make([]byte, pcpHeaderLength+pcpMapLength)
+	msg[0] = pcpVersion
+	msg[1] = pcpOpMap
+	binary.BigEndian.PutUint32(msg[4:8], lifetime)
+	clientIP := client.As16()
+	copy(msg[8:24], clientIP[:])
+	opcode := msg[pcpHeaderLength:]
+	copy(opcode[0:12], nonce)
+	opcode[12] = pcpProtocolUDP
+	binary.BigEndian.PutUint16(opcode[16:18], internalPort)
+	// suggested external address of ::ffff:0.0.0.0 asks for any ipv4 address
+	suggested := netip.AddrFrom4([4]byte{}).As16()
+	copy(opcode[20:36], suggested[:])
+	return msg
+}
+
+func acceptPCPMap(nonce []byte) func([]byte) bool {
+	return func(resp []byte) bool {
+		return len(resp) >= pcpHeaderLength+pcpMapLength &&
+			resp[0] == pcpVersion &&
+			resp[1] == pcpOpResponse|pcpOpMap &&
+			bytes.Equal(resp[pcpHeaderLength:pcpHeaderLength+12], nonce)
+	}
+}
+
+// localAddress returns the local address used to reach the gateway, which PCP
+// requires in every request.
+func localAddress(gateway netip.Addr) (netip.Addr, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(gateway.String(), strconv.Itoa(natpmpPort)))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not dial gateway: %w", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// pcpResponse builds a PCP MAP response to the request, assigning the given
+// external address.
+func pcpResponse(request []byte, result byte, external netip.Addr) []byte {
+	resp := make([]byte, pcpHeaderLength+pcpMapLength)
+	resp[0] = pcpVersion
+	resp[1] = pcpOpResponse | pcpOpMap
+	resp[3] = result
+	copy(resp[4:8], request[4:8])
+	binary.BigEndian.PutUint32(resp[8:12], 1234)
+	// the nonce, protocol and internal port are echoed back
+	copy(resp[pcpHeaderLength:pcpHeaderLength+20], request[pcpHeaderLength:pcpHeaderLength+20])
+	binary.BigEndian.PutUint16(resp[pcpHeaderLength+18:pcpHeaderLength+20], 40000)
+	a := external.As16()
+	copy(resp[pcpHeaderLength+20:], a[:])
+	return resp
+}
+
+func TestLookupPCP(t *testing.T) {
+	external := netip.MustParseAddr("198.51.100.4")
+	tests := []struct {
+		name     string
+		result   byte
+		external netip.Addr
+		want     netip.Addr
+		wantErr  string
+	}{
+		{name: "address", external: external, want: external},
+		{name: "result code", result: 8, external: netip.IPv6Unspecified(), wantErr: "result code 8"},
+		{name: "not ipv4", external: netip.MustParseAddr("2001:db8::4"), wantErr: "non ipv4 address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lock sync.Mutex
+			lifetimes := make([]uint32, 0)
+			gateway := stubGateway(t, func(request []byte) [][]byte {
+				if len(request) != pcpHeaderLength+pcpMapLength || request[0] != pcpVersion || request[1] != pcpOpMap {
+					return nil
+				}
+				lock.Lock()
+				lifetimes = append(lifetimes, binary.BigEndian.Uint32(request[4:8]))
+				lock.Unlock()
+				// a response for another nonce is ignored
+				other := pcpResponse(request, 0, netip.MustParseAddr("192.0.2.1"))
+				other[pcpHeaderLength] ^= 0xff
+				return [][]byte{other, pcpResponse(request, tt.result, tt.external)}
+			})
+			got, err := LookupPCP(gateway)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LookupPCP() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LookupPCP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("LookupPCP() = %s, want %s", got, tt.want)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			// the mapping is requested, then deleted with a lifetime of 0
+			if len(lifetimes) != 2 || lifetimes[0] != pcpMapLifetime || lifetimes[1] != 0 {
+				t.Fatalf("map request lifetimes = %v, want [%d 0]", lifetimes, pcpMapLifetime)
+			}
+		})
+	}
+}
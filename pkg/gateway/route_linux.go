@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const rtfGateway = 0x2
+
+// DefaultGateway returns the ipv4 default gateway of this host, read from the
+// kernel routing table in /proc/net/route.
+func DefaultGateway() (netip.Addr, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&rtfGateway == 0 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// addresses are written in host (little endian) byte order
+		var ip [4]byte
+		binary.BigEndian.PutUint32(ip[:], binary.LittleEndian.Uint32(raw))
+		return netip.AddrFrom4(ip), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.Addr{}, fmt.Errorf("no default route found")
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package gateway
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// DefaultGateway is only supported on linux. Elsewhere the gateway address has
+// to be given.
+func DefaultGateway() (netip.Addr, error) {
+	return netip.Addr{}, errors.New("finding the default gateway is only supported on linux")
+}
@@ -0,0 +1,209 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SSDPAddress is the multicast address that SSDP discovery requests are sent
+// to.
+var SSDPAddress = "239.255.255.250:1900"
+
+var igdDeviceTypes = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+}
+
+var wanServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// LookupUPnP asks an Internet Gateway Device for its external address with
+// the GetExternalIPAddress action of its WAN connection service. The location
+// is the URL of the device description. If it is empty, it is discovered with
+// SSDP.
+func LookupUPnP(location string) (netip.Addr, error) {
+	if location == "" {
+		var err error
+		if location, err = discoverIGD(); err != nil {
+			return netip.Addr{}, err
+		}
+	}
+	serviceType, controlURL, err := findWANService(location)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return getExternalIPAddress(serviceType, controlURL)
+}
+
+// discoverIGD sends an SSDP M-SEARCH for internet gateway devices and returns
+// the description location of the first to respond.
+func discoverIGD() (string, error) {
+	ssdpAddr, err := net.ResolveUDPAddr("udp4", SSDPAddress)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve ssdp address: %w", err)
+	}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", fmt.Errorf("could not open ssdp socket: %w", err)
+	}
+	defer conn.Close()
+	for _, deviceType := range igdDeviceTypes {
+		search := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + SSDPAddress + "\r\n" +
+			"ST: " + deviceType + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(search), ssdpAddr); err != nil {
+			return "", fmt.Errorf("could not send ssdp search: %w", err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(DefaultTimeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no internet gateway device found: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); location != "" && resp.StatusCode == http.StatusOK {
+			return location, nil
+		}
+	}
+}
+
+type upnpDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Services   []upnpService `xml:"serviceList>service"`
+	Devices    []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// findWANService fetches the device description at the location and searches
+// its (nested) devices for a WAN connection service, returning the service
+// type and absolute control URL.
+func findWANService(location string) (string, string, error) {
+	client := http.Client{Timeout: DefaultTimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return "", "", fmt.Errorf("could not fetch device description: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("could not fetch device description: %s", resp.Status)
+	}
+	var desc upnpDescription
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return "", "", fmt.Errorf("could not decode device description: %w", err)
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse location: %w", err)
+	}
+	if desc.URLBase != "" {
+		if base, err = url.Parse(desc.URLBase); err != nil {
+			return "", "", fmt.Errorf("could not parse url base: %w", err)
+		}
+	}
+	for _, serviceType := range wanServiceTypes {
+		if service, ok := findService(desc.Device, serviceType); ok {
+			controlURL, err := base.Parse(service.ControlURL)
+			if err != nil {
+				return "", "", fmt.Errorf("could not parse control url: %w", err)
+			}
+			return serviceType, controlURL.String(), nil
+		}
+	}
+	return "", "", errors.New("device has no wan connection service")
+}
+
+func findService(device upnpDevice, serviceType string) (upnpService, bool) {
+	for _, service := range device.Services {
+		if service.ServiceType == serviceType {
+			return service, true
+		}
+	}
+	for _, d := range device.Devices {
+		if service, ok := findService(d, serviceType); ok {
+			return service, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// getExternalIPAddress calls the GetExternalIPAddress SOAP action on the
+// service.
+func getExternalIPAddress(serviceType, controlURL string) (netip.Addr, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"></u:GetExternalIPAddress></s:Body>` +
+		`</s:Envelope>`
+	req, err := http.NewRequest(http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+	client := http.Client{Timeout: DefaultTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not call GetExternalIPAddress: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("could not call GetExternalIPAddress: %s", resp.Status)
+	}
+	address, err := findElement(resp.Body, "NewExternalIPAddress")
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not read GetExternalIPAddress response: %w", err)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(address))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to parse external address: %w", err)
+	}
+	return addr, nil
+}
+
+// findElement returns the text of the first element with the given local name
+// in the xml document.
+func findElement(r io.Reader, name string) (string, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("element %s not found: %w", name, err)
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == name {
+			var text string
+			if err := decoder.DecodeElement(&text, &start); err != nil {
+				return "", err
+			}
+			return text, nil
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const nestedDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  %s
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+        <controlURL>/l3f</controlURL>
+      </service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANPPPConnection:1</serviceType>
+                <controlURL>/ppp</controlURL>
+              </service>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>ctl/ip</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+// describe serves the device description, with the given URLBase element (if
+// any), at /desc/root.xml.
+func describe(t *testing.T, urlBase string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/desc/root.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, strings.Replace(nestedDescription, "%s", urlBase, 1))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFindWANService(t *testing.T) {
+	t.Run("nested device", func(t *testing.T) {
+		server := describe(t, "")
+		serviceType, controlURL, err := findWANService(server.URL + "/desc/root.xml")
+		if err != nil {
+			t.Fatalf("findWANService() error = %v", err)
+		}
+		// WANIPConnection is preferred over WANPPPConnection
+		if want := "urn:schemas-upnp-org:service:WANIPConnection:1"; serviceType != want {
+			t.Fatalf("findWANService() service = %s, want %s", serviceType, want)
+		}
+		if want := server.URL + "/desc/ctl/ip"; controlURL != want {
+			t.Fatalf("findWANService() control url = %s, want %s", controlURL, want)
+		}
+	})
+	t.Run("url base", func(t *testing.T) {
+		server := describe(t, "<URLBase>http://192.0.2.1:5000/</URLBase>")
+		_, controlURL, err := findWANService(server.URL + "/desc/root.xml")
+		if err != nil {
+			t.Fatalf("findWANService() error = %v", err)
+		}
+		if want := "http://192.0.2.1:5000/ctl/ip"; controlURL != want {
+			t.Fatalf("findWANService() control url = %s, want %s", controlURL, want)
+		}
+	})
+	t.Run("no wan service", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `<root><device><deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType></device></root>`)
+		}))
+		defer server.Close()
+		if _, _, err := findWANService(server.URL); err == nil {
+			t.Fatal("findWANService() found a service on a device without one")
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		server := describe(t, "")
+		if _, _, err := findWANService(server.URL + "/missing.xml"); err == nil {
+			t.Fatal("findWANService() did not fail for a missing description")
+		}
+	})
+}
+
+func TestGetExternalIPAddress(t *testing.T) {
+	const serviceType = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    netip.Addr
+		wantErr bool
+	}{
+		{
+			name:   "address",
+			status: http.StatusOK,
+			body: `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="` + serviceType + `">
+<NewExternalIPAddress> 198.51.100.4 </NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`,
+			want: netip.MustParseAddr("198.51.100.4"),
+		},
+		{
+			name:    "fault",
+			status:  http.StatusInternalServerError,
+			body:    `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault/></s:Body></s:Envelope>`,
+			wantErr: true,
+		},
+		{
+			name:    "no address",
+			status:  http.StatusOK,
+			body:    `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body/></s:Envelope>`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid address",
+			status:  http.StatusOK,
+			body:    `<r><NewExternalIPAddress>not an address</NewExternalIPAddress></r>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("request method = %s, want POST", r.Method)
+				}
+				if want := `"` + serviceType + `#GetExternalIPAddress"`; r.Header.Get("SOAPAction") != want {
+					t.Errorf("SOAPAction = %s, want %s", r.Header.Get("SOAPAction"), want)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if !strings.Contains(string(body), `<u:GetExternalIPAddress xmlns:u="`+serviceType+`">`) {
+					t.Errorf("request body has no GetExternalIPAddress action: %s", body)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+			got, err := getExternalIPAddress(serviceType, server.URL+"/ctl/ip")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("getExternalIPAddress() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getExternalIPAddress() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("getExternalIPAddress() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupUPnP(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/desc/root.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Replace(nestedDescription, "%s", "", 1))
+	})
+	mux.HandleFunc("/desc/ctl/ip", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<r><NewExternalIPAddress>198.51.100.4</NewExternalIPAddress></r>`)
+	})
+	got, err := LookupUPnP(server.URL + "/desc/root.xml")
+	if err != nil {
+		t.Fatalf("LookupUPnP() error = %v", err)
+	}
+	if want := netip.MustParseAddr("198.51.100.4"); got != want {
+		t.Fatalf("LookupUPnP() = %s, want %s", got, want)
+	}
+}
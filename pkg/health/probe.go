@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultTimeout is how long a probe waits for a response when no timeout is
+// given.
+var DefaultTimeout = 5 * time.Second
+
+// Probe is an HTTP(S) GET of an app, made through traefik. Rather than
+// resolving the hostname being probed (which may have had its records
+// withdrawn), requests are sent straight to traefik at Address (which must be
+// set), with the hostname given as the Host header and TLS server name.
+type Probe struct {
+	Scheme   string
+	Address  string
+	Path     string
+	Status   int
+	Timeout  time.Duration
+	Insecure bool
+}
+
+// Check probes the given hostname, returning an error if the request fails or
+// the response has an unexpected status. Redirects are not followed. When no
+// status is set, any 2xx status is accepted.
+func (p Probe) Check(hostname string) error {
+	scheme := p.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	address := p.Address
+	if address == "" {
+		return fmt.Errorf("no address to send the probe to")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		port := "443"
+		if scheme == "http" {
+			port = "80"
+		}
+		address = net.JoinHostPort(address, port)
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSClientConfig:   &tls.Config{ServerName: hostname, InsecureSkipVerify: p.Insecure},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	target := url.URL{Scheme: scheme, Host: hostname, Path: p.Path}
+	resp, err := client.Get(target.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case p.Status == 0 && resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case p.Status != 0 && resp.StatusCode == p.Status:
+		return nil
+	}
+	return fmt.Errorf("unexpected status: %s", resp.Status)
+}
@@ -0,0 +1,71 @@
+package health
+
+import "sync"
+
+// Tracker holds the health of a set of keys (such as hostnames) across probes,
+// with hysteresis: a healthy key only becomes unhealthy after failing a number
+// of probes in a row, and an unhealthy key only becomes healthy again after
+// passing a number of probes in a row. This stops a single slow response from
+// withdrawing a record.
+type Tracker struct {
+	lock   *sync.Mutex
+	states map[string]*state
+}
+
+type state struct {
+	healthy bool
+	streak  int
+}
+
+// NewTracker creates a tracker with no known keys.
+func NewTracker() *Tracker {
+	return &Tracker{
+		lock:   &sync.Mutex{},
+		states: make(map[string]*state),
+	}
+}
+
+// Observe records the result of a probe of key and returns whether the key is
+// now healthy. A change of health is only accepted once rise (when becoming
+// healthy) or fall (when becoming unhealthy) results in a row agree with it.
+// Keys start out healthy, so that a key is only taken as unhealthy after fall
+// failed results, even straight after a restart.
+func (t *Tracker) Observe(key string, passed bool, rise, fall int) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.states[key]
+	if !ok {
+		s = &state{healthy: true}
+		t.states[key] = s
+	}
+	if passed == s.healthy {
+		s.streak = 0
+		return s.healthy
+	}
+	s.streak++
+	needed := fall
+	if passed {
+		needed = rise
+	}
+	if s.streak >= needed {
+		s.healthy = passed
+		s.streak = 0
+	}
+	return s.healthy
+}
+
+// Forget drops any keys not in keep, so that keys which are no longer probed
+// start afresh should they return.
+func (t *Tracker) Forget(keep ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	kept := make(map[string]bool)
+	for _, key := range keep {
+		kept[key] = true
+	}
+	for key := range t.states {
+		if !kept[key] {
+			delete(t.states, key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package health
+
+import "testing"
+
+func TestTrackerObserve(t *testing.T) {
+	tests := []struct {
+		name       string
+		rise, fall int
+		results    []bool
+		want       []bool
+	}{
+		{
+			name:    "starts healthy",
+			rise:    1,
+			fall:    1,
+			results: []bool{true, true},
+			want:    []bool{true, true},
+		},
+		{
+			name:    "first failure waits for fall",
+			rise:    1,
+			fall:    3,
+			results: []bool{false, false, false, false},
+			want:    []bool{true, true, false, false},
+		},
+		{
+			name:    "streak broken by a pass",
+			rise:    1,
+			fall:    2,
+			results: []bool{false, true, false, false},
+			want:    []bool{true, true, true, false},
+		},
+		{
+			name:    "rise needed to recover",
+			rise:    2,
+			fall:    1,
+			results: []bool{false, true, false, true, true},
+			want:    []bool{false, false, false, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker()
+			for i, passed := range tt.results {
+				if got := tracker.Observe("a.example.com", passed, tt.rise, tt.fall); got != tt.want[i] {
+					t.Fatalf("result %d: Observe() = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTrackerForget(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Observe("a.example.com", false, 1, 1)
+	tracker.Observe("b.example.com", false, 1, 1)
+	tracker.Forget("b.example.com")
+	if !tracker.Observe("a.example.com", true, 2, 1) {
+		t.Fatal("forgotten key did not start afresh")
+	}
+	if tracker.Observe("b.example.com", true, 2, 1) {
+		t.Fatal("kept key lost its state")
+	}
+}
@@ -0,0 +1,44 @@
+package ipaddr
+
+import "net/netip"
+
+// Damper holds on to an address until a different address has been observed
+// a number of times in a row. This stops a single bad lookup from being
+// published.
+type Damper struct {
+	confirmations int
+
+	current   netip.Addr
+	candidate netip.Addr
+	seen      int
+}
+
+// NewDamper creates a damper that requires a changed address to be observed
+// the given number of consecutive times before it is accepted. With 1 or
+// fewer, every change is accepted straight away.
+func NewDamper(confirmations int) *Damper {
+	return &Damper{confirmations: confirmations}
+}
+
+// Observe records a newly looked up address and returns the address that
+// should be used. The first address observed is accepted straight away.
+func (d *Damper) Observe(addr netip.Addr) netip.Addr {
+	switch {
+	case !d.current.IsValid() || addr == d.current:
+		d.current = addr
+		d.candidate = netip.Addr{}
+		d.seen = 0
+		return d.current
+	case addr == d.candidate:
+		d.seen++
+	default:
+		d.candidate = addr
+		d.seen = 1
+	}
+	if d.seen >= d.confirmations {
+		d.current = d.candidate
+		d.candidate = netip.Addr{}
+		d.seen = 0
+	}
+	return d.current
+}
@@ -0,0 +1,67 @@
+package ipaddr
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestDamper(t *testing.T) {
+	a := netip.MustParseAddr("192.0.2.1")
+	b := netip.MustParseAddr("192.0.2.2")
+	c := netip.MustParseAddr("192.0.2.3")
+	tests := []struct {
+		name          string
+		confirmations int
+		observed      []netip.Addr
+		want          []netip.Addr
+	}{
+		{
+			name:          "off",
+			confirmations: 1,
+			observed:      []netip.Addr{a, b, a},
+			want:          []netip.Addr{a, b, a},
+		},
+		{
+			name:          "zero taken as off",
+			confirmations: 0,
+			observed:      []netip.Addr{a, b},
+			want:          []netip.Addr{a, b},
+		},
+		{
+			name:          "first accepted",
+			confirmations: 3,
+			observed:      []netip.Addr{a, a},
+			want:          []netip.Addr{a, a},
+		},
+		{
+			name:          "change confirmed",
+			confirmations: 3,
+			observed:      []netip.Addr{a, b, b, b, b},
+			want:          []netip.Addr{a, a, a, b, b},
+		},
+		{
+			// a flap back to the current address starts the count again
+			name:          "reset on flap",
+			confirmations: 2,
+			observed:      []netip.Addr{a, b, a, b, b},
+			want:          []netip.Addr{a, a, a, a, b},
+		},
+		{
+			// as does a different candidate
+			name:          "reset on new candidate",
+			confirmations: 2,
+			observed:      []netip.Addr{a, b, c, b, c, c},
+			want:          []netip.Addr{a, a, a, a, a, c},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDamper(tt.confirmations)
+			for i, addr := range tt.observed {
+				if got := d.Observe(addr); got != tt.want[i] {
+					t.Fatalf("Observe(%s) #%d = %s, want %s", addr, i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
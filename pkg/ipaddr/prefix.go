@@ -0,0 +1,34 @@
+package ipaddr
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// WithSuffix returns the address made up of the network bits of the given
+// prefix followed by the host bits of the given suffix. This allows addresses
+// of hosts behind a router to be derived from a (possibly changing) delegated
+// prefix and a fixed interface identifier. For example, the prefix
+// `2001:db8:1:2::/64` and suffix `::11:22ff:fe33:4455` give
+// `2001:db8:1:2:11:22ff:fe33:4455`.
+func WithSuffix(prefix netip.Prefix, suffix netip.Addr) (netip.Addr, error) {
+	if !prefix.Addr().Is6() || !suffix.Is6() {
+		return netip.Addr{}, fmt.Errorf("prefix and suffix must both be ipv6")
+	}
+	p := prefix.Masked().Addr().As16()
+	s := suffix.As16()
+	bits := prefix.Bits()
+	for i := range p {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			mask := byte(0xff >> bits)
+			p[i] = p[i]&^mask | s[i]&mask
+			bits = 0
+		default:
+			p[i] = s[i]
+		}
+	}
+	return netip.AddrFrom16(p), nil
+}
@@ -0,0 +1,72 @@
+package ipaddr
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+type reservedPrefix struct {
+	prefix netip.Prefix
+	name   string
+}
+
+// reservedPrefixes are ranges that should never be published as the public
+// address of a host: private, shared, link-local, documentation and other
+// special purpose ranges (RFC 6890 and the IANA special-purpose registries).
+var reservedPrefixes = []reservedPrefix{
+	{netip.MustParsePrefix("0.0.0.0/8"), "this network"},
+	{netip.MustParsePrefix("10.0.0.0/8"), "private"},
+	{netip.MustParsePrefix("100.64.0.0/10"), "shared address space (cgnat)"},
+	{netip.MustParsePrefix("127.0.0.0/8"), "loopback"},
+	{netip.MustParsePrefix("169.254.0.0/16"), "link-local"},
+	{netip.MustParsePrefix("172.16.0.0/12"), "private"},
+	{netip.MustParsePrefix("192.0.0.0/24"), "ietf protocol assignments"},
+	{netip.MustParsePrefix("192.0.2.0/24"), "documentation"},
+	{netip.MustParsePrefix("192.88.99.0/24"), "6to4 relay anycast"},
+	{netip.MustParsePrefix("192.168.0.0/16"), "private"},
+	{netip.MustParsePrefix("198.18.0.0/15"), "benchmarking"},
+	{netip.MustParsePrefix("198.51.100.0/24"), "documentation"},
+	{netip.MustParsePrefix("203.0.113.0/24"), "documentation"},
+	{netip.MustParsePrefix("224.0.0.0/4"), "multicast"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "reserved"},
+	{netip.MustParsePrefix("::/128"), "unspecified"},
+	{netip.MustParsePrefix("::1/128"), "loopback"},
+	{netip.MustParsePrefix("::ffff:0:0/96"), "ipv4-mapped"},
+	{netip.MustParsePrefix("64:ff9b:1::/48"), "local-use nat64"},
+	{netip.MustParsePrefix("100::/64"), "discard-only"},
+	{netip.MustParsePrefix("2001::/23"), "ietf protocol assignments"},
+	{netip.MustParsePrefix("2001:db8::/32"), "documentation"},
+	{netip.MustParsePrefix("3fff::/20"), "documentation"},
+	{netip.MustParsePrefix("fc00::/7"), "unique local"},
+	{netip.MustParsePrefix("fe80::/10"), "link-local"},
+	{netip.MustParsePrefix("ff00::/8"), "multicast"},
+}
+
+// globalUnicast is the only ipv6 range currently allocated for global unicast.
+var globalUnicast = netip.MustParsePrefix("2000::/3")
+
+// Validate returns an error if the address is not one that should be published
+// as a public address. That is, if it falls within a private, shared (CGNAT),
+// link-local, documentation or other reserved range, or for ipv6, outside of
+// the global unicast range. Addresses within any of the given allowed prefixes
+// are always valid, for example to publish addresses of a tailscale network.
+func Validate(addr netip.Addr, allow ...netip.Prefix) error {
+	if !addr.IsValid() {
+		return fmt.Errorf("address is not valid")
+	}
+	addr = addr.Unmap()
+	for _, prefix := range allow {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	for _, reserved := range reservedPrefixes {
+		if reserved.prefix.Contains(addr) {
+			return fmt.Errorf("address %s is in the %s range %s", addr, reserved.name, reserved.prefix)
+		}
+	}
+	if addr.Is6() && !globalUnicast.Contains(addr) {
+		return fmt.Errorf("address %s is outside of the global unicast range %s", addr, globalUnicast)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package ipaddr
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		addr    string
+		allow   []string
+		wantErr bool
+	}{
+		{addr: "1.1.1.1"},
+		{addr: "2606:4700:4700::1111"},
+		{addr: "::ffff:1.1.1.1"},
+		{addr: "10.1.2.3", wantErr: true},
+		{addr: "172.16.0.1", wantErr: true},
+		{addr: "192.168.1.1", wantErr: true},
+		{addr: "100.64.0.1", wantErr: true},
+		{addr: "100.127.255.254", wantErr: true},
+		{addr: "100.128.0.1"},
+		{addr: "127.0.0.1", wantErr: true},
+		{addr: "169.254.1.1", wantErr: true},
+		{addr: "192.0.2.1", wantErr: true},
+		{addr: "198.51.100.1", wantErr: true},
+		{addr: "203.0.113.1", wantErr: true},
+		{addr: "0.1.2.3", wantErr: true},
+		{addr: "224.0.0.1", wantErr: true},
+		{addr: "255.255.255.255", wantErr: true},
+		{addr: "::ffff:192.168.1.1", wantErr: true},
+		{addr: "::1", wantErr: true},
+		{addr: "::", wantErr: true},
+		{addr: "fd00::1", wantErr: true},
+		{addr: "fe80::1", wantErr: true},
+		{addr: "ff02::1", wantErr: true},
+		{addr: "2001:db8::1", wantErr: true},
+		{addr: "3fff::1", wantErr: true},
+		{addr: "2001::1", wantErr: true},
+		{addr: "4000::1", wantErr: true},
+		{addr: "100.100.1.1", allow: []string{"100.64.0.0/10"}},
+		{addr: "fd7a:115c:a1e0::1", allow: []string{"fd7a:115c:a1e0::/48"}},
+		{addr: "fd00::1", allow: []string{"fd7a:115c:a1e0::/48"}, wantErr: true},
+		{addr: "192.168.1.1", allow: []string{"192.168.1.0/24"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			allow := make([]netip.Prefix, len(tt.allow))
+			for i, prefix := range tt.allow {
+				allow[i] = netip.MustParsePrefix(prefix)
+			}
+			err := Validate(netip.MustParseAddr(tt.addr), allow...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate(%s) error = %v, want error %v", tt.addr, err, tt.wantErr)
+			}
+		})
+	}
+	if err := Validate(netip.Addr{}); err == nil {
+		t.Fatal("Validate() of the zero address did not fail")
+	}
+}
@@ -0,0 +1,132 @@
+package match
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Pattern matches strings either as a shell style glob (e.g.
+// `*.internal.example.com`) or, when wrapped in slashes, as a regular
+// expression (e.g. `/^api-[0-9]+\./`). Globs are matched case-insensitively.
+type Pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// Compile parses the given pattern, returning an error if it is neither a
+// valid glob nor a valid regular expression.
+func Compile(pattern string) (*Pattern, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %w", pattern, err)
+		}
+		return &Pattern{re: re}, nil
+	}
+	glob := strings.ToLower(pattern)
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %s: %w", pattern, err)
+	}
+	return &Pattern{glob: glob}, nil
+}
+
+// Match reports whether the string matches the pattern.
+func (p *Pattern) Match(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	ok, _ := path.Match(p.glob, strings.ToLower(s))
+	return ok
+}
+
+func (p *Pattern) String() string {
+	if p.re != nil {
+		return "/" + p.re.String() + "/"
+	}
+	return p.glob
+}
+
+// Subject is the set of attributes of a domain that rules can be matched
+// against. A domain can be used by more than one router, so has the names,
+// providers and entrypoints of all of them.
+type Subject struct {
+	Domain      string
+	Routers     []string
+	Providers   []string
+	EntryPoints []string
+}
+
+// RuleConfig is the configuration form of a Rule, where each field is a
+// pattern. Fields left empty match anything. Providers may be given with or
+// without a leading `@` (e.g. `@docker` or `docker`).
+type RuleConfig struct {
+	Domain     string `mapstructure:"domain"`
+	Router     string `mapstructure:"router"`
+	Provider   string `mapstructure:"provider"`
+	EntryPoint string `mapstructure:"entrypoint"`
+}
+
+// Rule matches a subject when every pattern it has been given matches. Where a
+// subject has many values for an attribute (such as routers), the pattern
+// needs to match only one of them.
+type Rule struct {
+	Domain     *Pattern
+	Router     *Pattern
+	Provider   *Pattern
+	EntryPoint *Pattern
+}
+
+// Compile parses each of the patterns in the rule config.
+func (c RuleConfig) Compile() (*Rule, error) {
+	var err error
+	r := &Rule{}
+	if c.Domain != "" {
+		if r.Domain, err = Compile(c.Domain); err != nil {
+			return nil, err
+		}
+	}
+	if c.Router != "" {
+		if r.Router, err = Compile(c.Router); err != nil {
+			return nil, err
+		}
+	}
+	if c.Provider != "" {
+		if r.Provider, err = Compile(strings.TrimPrefix(c.Provider, "@")); err != nil {
+			return nil, err
+		}
+	}
+	if c.EntryPoint != "" {
+		if r.EntryPoint, err = Compile(c.EntryPoint); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Match reports whether the subject satisfies the rule.
+func (r *Rule) Match(s Subject) bool {
+	if r.Domain != nil && !r.Domain.Match(s.Domain) {
+		return false
+	}
+	if r.Router != nil && !anyMatch(r.Router, s.Routers) {
+		return false
+	}
+	if r.Provider != nil && !anyMatch(r.Provider, s.Providers) {
+		return false
+	}
+	if r.EntryPoint != nil && !anyMatch(r.EntryPoint, s.EntryPoints) {
+		return false
+	}
+	return true
+}
+
+func anyMatch(p *Pattern, values []string) bool {
+	for _, v := range values {
+		if p.Match(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package netif
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDeprecated = 0x20
+	ifaFlagTentative  = 0x40
+)
+
+// addressFlags reads the IPv6 address flags of the named interface from
+// /proc/net/if_inet6, since these are not exposed by the net package. Each
+// line of the file is formatted as:
+//
+//	<address> <ifindex> <prefixlen> <scope> <flags> <ifname>
+func addressFlags(name string) (map[netip.Addr]flags, error) {
+	addrFlags := make(map[netip.Addr]flags)
+	f, err := os.Open("/proc/net/if_inet6")
+	if os.IsNotExist(err) {
+		// ipv6 is disabled
+		return addrFlags, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || fields[5] != name {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(raw)
+		if !ok {
+			continue
+		}
+		ifaFlags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		addrFlags[addr] = flags{
+			temporary:  ifaFlags&ifaFlagTemporary != 0,
+			deprecated: ifaFlags&ifaFlagDeprecated != 0,
+			tentative:  ifaFlags&ifaFlagTentative != 0,
+		}
+	}
+	return addrFlags, scanner.Err()
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package netif
+
+import "net/netip"
+
+// addressFlags is only supported on linux. Elsewhere every address is treated
+// as stable and preferred.
+func addressFlags(name string) (map[netip.Addr]flags, error) {
+	return make(map[netip.Addr]flags), nil
+}
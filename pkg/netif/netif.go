@@ -0,0 +1,203 @@
+package netif
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Address is an address assigned to a local network interface.
+type Address struct {
+	Addr   netip.Addr
+	Prefix netip.Prefix
+
+	// Temporary is set for IPv6 privacy extension (RFC 8981) addresses. These
+	// rotate regularly so are usually not wanted in DNS.
+	Temporary bool
+	// Deprecated is set for IPv6 addresses whose preferred lifetime has
+	// expired, such as those from a prefix that has since been replaced.
+	Deprecated bool
+	// Tentative is set for IPv6 addresses that have not yet passed duplicate
+	// address detection, and so can not be used yet.
+	Tentative bool
+}
+
+// Scope describes the reachability of an address.
+type Scope string
+
+const (
+	ScopeGlobal    Scope = "global"
+	ScopeULA       Scope = "ula"
+	ScopePrivate   Scope = "private"
+	ScopeShared    Scope = "shared"
+	ScopeLinkLocal Scope = "link-local"
+	ScopeLoopback  Scope = "loopback"
+)
+
+// flags are the per-address flags that the net package does not expose.
+type flags struct {
+	temporary  bool
+	deprecated bool
+	tentative  bool
+}
+
+type AddressFilter func(addresses []Address) []Address
+
+// GetAddresses returns the addresses assigned to the named interface, filtered
+// by the given filters in the order provided.
+func GetAddresses(name string, filters ...AddressFilter) ([]Address, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not find interface %s: %w", name, err)
+	}
+	ifAddrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("could not list addresses of interface %s: %w", name, err)
+	}
+	flags, err := addressFlags(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read address flags of interface %s: %w", name, err)
+	}
+	addresses := make([]Address, 0)
+	for _, ifAddr := range ifAddrs {
+		ipNet, ok := ifAddr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		ones, _ := ipNet.Mask.Size()
+		address := Address{
+			Addr:   addr,
+			Prefix: netip.PrefixFrom(addr, ones).Masked(),
+		}
+		if f, ok := flags[addr]; ok {
+			address.Temporary = f.temporary
+			address.Deprecated = f.deprecated
+			address.Tentative = f.tentative
+		}
+		addresses = append(addresses, address)
+	}
+	for _, filter := range filters {
+		addresses = filter(addresses)
+	}
+	return addresses, nil
+}
+
+// LookupIP returns the first address of the given family on the named
+// interface that passes all the given filters. Deprecated and tentative
+// addresses are always skipped.
+func LookupIP(name string, ipv6 bool, filters ...AddressFilter) (netip.Addr, error) {
+	filters = append([]AddressFilter{AddressFilterFamily(ipv6), AddressFilterDeprecated(false), AddressFilterTentative(false)}, filters...)
+	addresses, err := GetAddresses(name, filters...)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if len(addresses) == 0 {
+		return netip.Addr{}, fmt.Errorf("no matching address found on interface %s", name)
+	}
+	return addresses[0].Addr, nil
+}
+
+// sharedPrefix is the shared address space (RFC 6598) used by carrier-grade
+// NAT, and by overlay networks such as tailscale.
+var sharedPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// Scope returns the scope of the address. Addresses in the shared address
+// space (CGNAT) are not reachable from the internet, so are given a scope of
+// their own rather than being taken as global.
+func (a Address) Scope() Scope {
+	switch {
+	case a.Addr.IsLoopback():
+		return ScopeLoopback
+	case a.Addr.IsLinkLocalUnicast():
+		return ScopeLinkLocal
+	case a.Addr.Is6() && a.Addr.IsPrivate():
+		return ScopeULA
+	case a.Addr.IsPrivate():
+		return ScopePrivate
+	case sharedPrefix.Contains(a.Addr.Unmap()):
+		return ScopeShared
+	default:
+		return ScopeGlobal
+	}
+}
+
+// AddressFilterFamily returns an address filter that keeps only IPv6 addresses
+// if ipv6 is set, or only IPv4 addresses otherwise.
+func AddressFilterFamily(ipv6 bool) AddressFilter {
+	return func(addresses []Address) []Address {
+		filteredAddresses := make([]Address, 0)
+		for _, address := range addresses {
+			if address.Addr.Is6() == ipv6 {
+				filteredAddresses = append(filteredAddresses, address)
+			}
+		}
+		return filteredAddresses
+	}
+}
+
+// AddressFilterScopeIn returns an address filter that filters addresses based
+// on the given scopes. If an address has a scope that is **not** in the given
+// list, it will be filtered out of any returned set.
+func AddressFilterScopeIn(scopes ...Scope) AddressFilter {
+	return func(addresses []Address) []Address {
+		if len(scopes) == 0 {
+			return addresses
+		}
+		filteredAddresses := make([]Address, 0)
+		for _, address := range addresses {
+			for _, scope := range scopes {
+				if address.Scope() == scope {
+					filteredAddresses = append(filteredAddresses, address)
+				}
+			}
+		}
+		return filteredAddresses
+	}
+}
+
+// AddressFilterTemporary returns an address filter that keeps only temporary
+// addresses if temporary is set, or only stable addresses otherwise.
+func AddressFilterTemporary(temporary bool) AddressFilter {
+	return func(addresses []Address) []Address {
+		filteredAddresses := make([]Address, 0)
+		for _, address := range addresses {
+			if address.Temporary == temporary {
+				filteredAddresses = append(filteredAddresses, address)
+			}
+		}
+		return filteredAddresses
+	}
+}
+
+// AddressFilterDeprecated returns an address filter that keeps only deprecated
+// addresses if deprecated is set, or only preferred addresses otherwise.
+func AddressFilterDeprecated(deprecated bool) AddressFilter {
+	return func(addresses []Address) []Address {
+		filteredAddresses := make([]Address, 0)
+		for _, address := range addresses {
+			if address.Deprecated == deprecated {
+				filteredAddresses = append(filteredAddresses, address)
+			}
+		}
+		return filteredAddresses
+	}
+}
+
+// AddressFilterTentative returns an address filter that keeps only tentative
+// addresses if tentative is set, or only usable addresses otherwise.
+func AddressFilterTentative(tentative bool) AddressFilter {
+	return func(addresses []Address) []Address {
+		filteredAddresses := make([]Address, 0)
+		for _, address := range addresses {
+			if address.Tentative == tentative {
+				filteredAddresses = append(filteredAddresses, address)
+			}
+		}
+		return filteredAddresses
+	}
+}
@@ -0,0 +1,51 @@
+package netif
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		addr string
+		want Scope
+	}{
+		{addr: "1.1.1.1", want: ScopeGlobal},
+		{addr: "2606:4700:4700::1111", want: ScopeGlobal},
+		{addr: "100.63.255.255", want: ScopeGlobal},
+		{addr: "100.64.0.1", want: ScopeShared},
+		{addr: "100.101.102.103", want: ScopeShared},
+		{addr: "100.127.255.254", want: ScopeShared},
+		{addr: "100.128.0.1", want: ScopeGlobal},
+		{addr: "10.0.0.1", want: ScopePrivate},
+		{addr: "192.168.1.1", want: ScopePrivate},
+		{addr: "fd7a:115c:a1e0::1", want: ScopeULA},
+		{addr: "169.254.0.1", want: ScopeLinkLocal},
+		{addr: "fe80::1", want: ScopeLinkLocal},
+		{addr: "127.0.0.1", want: ScopeLoopback},
+		{addr: "::1", want: ScopeLoopback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := (Address{Addr: netip.MustParseAddr(tt.addr)}).Scope(); got != tt.want {
+				t.Fatalf("Scope() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressFilterScopeIn(t *testing.T) {
+	addresses := []Address{
+		{Addr: netip.MustParseAddr("100.101.102.103")},
+		{Addr: netip.MustParseAddr("198.51.100.1")},
+		{Addr: netip.MustParseAddr("192.168.1.1")},
+	}
+	got := AddressFilterScopeIn(ScopeGlobal)(addresses)
+	if len(got) != 1 || got[0].Addr != addresses[1].Addr {
+		t.Fatalf("global addresses = %v, want only %s", got, addresses[1].Addr)
+	}
+	got = AddressFilterScopeIn(ScopeShared)(addresses)
+	if len(got) != 1 || got[0].Addr != addresses[0].Addr {
+		t.Fatalf("shared addresses = %v, want only %s", got, addresses[0].Addr)
+	}
+}
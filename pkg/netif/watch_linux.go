@@ -0,0 +1,89 @@
+package netif
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// netlink multicast groups for address notifications, from linux/rtnetlink.h
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// Watch subscribes to the kernel's address notifications over netlink
+// (RTM_NEWADDR and RTM_DELADDR) and signals on the returned channel whenever an
+// address on the named interface is added, removed or changed. Bursts of
+// notifications are coalesced into a single signal. Should notifications be
+// lost (as the socket buffer overflowed), a signal is sent too, so that the
+// address is checked again. The interface does not have to exist when the
+// watch is started. The watch stops and the channel is closed once the given
+// context is done.
+func Watch(ctx context.Context, name string) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("could not bind netlink socket: %w", err)
+	}
+	// a receive timeout lets the read loop notice the context being done
+	timeout := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("could not set netlink socket timeout: %w", err)
+	}
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		defer close(changes)
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err == syscall.ENOBUFS {
+				// notifications were dropped, any of which may have been
+				// for the interface
+				changed()
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, msg := range msgs {
+				if msg.Header.Type != syscall.RTM_NEWADDR && msg.Header.Type != syscall.RTM_DELADDR {
+					continue
+				}
+				if len(msg.Data) < syscall.SizeofIfAddrmsg {
+					continue
+				}
+				ifAddrMsg := (*syscall.IfAddrmsg)(unsafe.Pointer(&msg.Data[0]))
+				// the index is looked up each time as the interface may have
+				// been recreated since the watch started
+				iface, err := net.InterfaceByName(name)
+				if err != nil || iface.Index != int(ifAddrMsg.Index) {
+					continue
+				}
+				changed()
+			}
+		}
+	}()
+	return changes, nil
+}
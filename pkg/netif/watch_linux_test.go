@@ -0,0 +1,124 @@
+package netif
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// changeAddress adds (RTM_NEWADDR) or removes (RTM_DELADDR) an ipv4 address
+// on the interface over netlink. The test is skipped when this is not
+// permitted, as changing addresses needs CAP_NET_ADMIN.
+func changeAddress(t *testing.T, msgType uint16, iface *net.Interface, addr netip.Addr) {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		t.Fatal(err)
+	}
+
+	a := addr.As4()
+	msg := make([]byte, syscall.NLMSG_HDRLEN+syscall.SizeofIfAddrmsg, 64)
+	flags := syscall.NLM_F_REQUEST | syscall.NLM_F_ACK
+	if msgType == syscall.RTM_NEWADDR {
+		flags |= syscall.NLM_F_CREATE | syscall.NLM_F_EXCL
+	}
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], uint16(flags))
+	binary.NativeEndian.PutUint32(msg[8:12], 1)
+	ifAddrMsg := msg[syscall.NLMSG_HDRLEN:]
+	ifAddrMsg[0] = syscall.AF_INET
+	ifAddrMsg[1] = 32
+	binary.NativeEndian.PutUint32(ifAddrMsg[4:8], uint32(iface.Index))
+	for _, attrType := range []uint16{syscall.IFA_LOCAL, syscall.IFA_ADDRESS} {
+		attr := make([]byte, syscall.SizeofRtAttr, syscall.SizeofRtAttr+4)
+		binary.NativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+4))
+		binary.NativeEndian.PutUint16(attr[2:4], attrType)
+		msg = append(msg, append(attr, a[:]...)...)
+	}
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil || len(msgs) == 0 || msgs[0].Header.Type != syscall.NLMSG_ERROR || len(msgs[0].Data) < 4 {
+		t.Fatalf("unexpected netlink response: %v", err)
+	}
+	switch errno := syscall.Errno(-int32(binary.NativeEndian.Uint32(msgs[0].Data[0:4]))); errno {
+	case 0:
+	case syscall.EPERM, syscall.EACCES:
+		t.Skipf("changing interface addresses needs CAP_NET_ADMIN: %v", errno)
+	default:
+		t.Fatalf("could not change address %s on %s: %v", addr, iface.Name, errno)
+	}
+}
+
+// received reports whether a signal is received on the channel within the
+// timeout.
+func received(changes <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-changes:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestWatch(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	addr := netip.MustParseAddr("127.0.0.223")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := Watch(ctx, "lo")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	others, err := Watch(ctx, "cloudflaere0")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	changeAddress(t, syscall.RTM_NEWADDR, lo, addr)
+	t.Cleanup(func() { changeAddress(t, syscall.RTM_DELADDR, lo, addr) })
+	if !received(changes, 5*time.Second) {
+		t.Fatal("no change signalled after adding an address")
+	}
+	changeAddress(t, syscall.RTM_DELADDR, lo, addr)
+	if !received(changes, 5*time.Second) {
+		t.Fatal("no change signalled after removing an address")
+	}
+	changeAddress(t, syscall.RTM_NEWADDR, lo, addr)
+	if received(others, 2*time.Second) {
+		t.Fatal("change signalled for an address on another interface")
+	}
+
+	// the channel is closed once the context is done
+	cancel()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("channel not closed after the context was done")
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package netif
+
+import (
+	"context"
+	"errors"
+)
+
+// Watch is only supported on linux, where address changes are reported over
+// netlink.
+func Watch(ctx context.Context, name string) (<-chan struct{}, error) {
+	return nil, errors.New("watching interface addresses is only supported on linux")
+}
@@ -0,0 +1,189 @@
+package stun
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	magicCookie uint32 = 0x2112A442
+
+	headerLength = 20
+
+	typeBindingRequest  uint16 = 0x0001
+	typeBindingResponse uint16 = 0x0101
+
+	attrMappedAddress    uint16 = 0x0001
+	attrXORMappedAddress uint16 = 0x0020
+
+	familyIPv4 byte = 0x01
+	familyIPv6 byte = 0x02
+)
+
+// DefaultServers is the set of public STUN servers used when none are
+// configured.
+var DefaultServers = []string{
+	"stun.cloudflare.com:3478",
+	"stun.l.google.com:19302",
+}
+
+// DefaultTimeout is how long to wait for a response from each STUN server.
+var DefaultTimeout = 3 * time.Second
+
+// LookupIP sends a STUN binding request (RFC 5389) to each of the given
+// servers in turn and returns the mapped address reported by the first one to
+// answer. Servers should be given as host:port. Only the address family
+// requested is used to reach the servers, so the mapped address returned is of
+// that same family.
+func LookupIP(servers []string, ipv6 bool) (netip.Addr, error) {
+	if len(servers) == 0 {
+		servers = DefaultServers
+	}
+	network := "udp4"
+	if ipv6 {
+		network = "udp6"
+	}
+	errs := make([]error, 0)
+	for _, server := range servers {
+		addr, err := lookup(network, server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stun server %s: %w", server, err))
+			continue
+		}
+		if addr.Is4() == ipv6 {
+			errs = append(errs, fmt.Errorf("stun server %s: mapped address %s is of the wrong family", server, addr))
+			continue
+		}
+		return addr, nil
+	}
+	return netip.Addr{}, errors.Join(errs...)
+}
+
+func lookup(network, server string) (netip.Addr, error) {
+	conn, err := net.Dial(network, server)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not dial: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(DefaultTimeout)); err != nil {
+		return netip.Addr{}, err
+	}
+	request, txID, err := newBindingRequest()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not create binding request: %w", err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return netip.Addr{}, fmt.Errorf("could not send binding request: %w", err)
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("could not read binding response: %w", err)
+		}
+		addr, err := parseBindingResponse(buf[:n], txID)
+		if errors.Is(err, errTransactionMismatch) {
+			continue
+		}
+		return addr, err
+	}
+}
+
+func newBindingRequest() ([]byte, []byte, error) {
+	msg := make([]byte, headerLength)
+	binary.BigEndian.PutUint16(msg[0:2], typeBindingRequest)
+	binary.BigEndian.PutUint16(msg[2:4], 0)
+	binary.BigEndian.PutUint32(msg[4:8], magicCookie)
+	if _, err := rand.Read(msg[8:20]); err != nil {
+		return nil, nil, err
+	}
+	return msg, msg[8:20], nil
+}
+
+var errTransactionMismatch = errors.New("transaction id mismatch")
+
+// parseBindingResponse reads the mapped address out of a binding success
+// response. The XOR-MAPPED-ADDRESS attribute is preferred, falling back to
+// MAPPED-ADDRESS for servers that only implement RFC 3489.
+func parseBindingResponse(msg, txID []byte) (netip.Addr, error) {
+	if len(msg) < headerLength {
+		return netip.Addr{}, fmt.Errorf("response too short")
+	}
+	if !bytes.Equal(msg[8:20], txID) {
+		return netip.Addr{}, errTransactionMismatch
+	}
+	if t := binary.BigEndian.Uint16(msg[0:2]); t != typeBindingResponse {
+		return netip.Addr{}, fmt.Errorf("unexpected message type 0x%04x", t)
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if headerLength+length > len(msg) {
+		return netip.Addr{}, fmt.Errorf("response truncated")
+	}
+	var mapped netip.Addr
+	attrs := msg[headerLength : headerLength+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLength > len(attrs) {
+			return netip.Addr{}, fmt.Errorf("attribute 0x%04x truncated", attrType)
+		}
+		value := attrs[4 : 4+attrLength]
+		switch attrType {
+		case attrXORMappedAddress:
+			return parseAddress(value, msg[4:20])
+		case attrMappedAddress:
+			if addr, err := parseAddress(value, nil); err == nil {
+				mapped = addr
+			}
+		}
+		// attributes are padded to a multiple of 4 bytes
+		padded := (attrLength + 3) &^ 3
+		if 4+padded > len(attrs) {
+			break
+		}
+		attrs = attrs[4+padded:]
+	}
+	if mapped.IsValid() {
+		return mapped, nil
+	}
+	return netip.Addr{}, fmt.Errorf("response has no mapped address")
+}
+
+// parseAddress decodes a (XOR-)MAPPED-ADDRESS attribute value. When key is
+// non-nil, the address is XORed with it (the magic cookie followed by the
+// transaction id), as is done for XOR-MAPPED-ADDRESS.
+func parseAddress(value, key []byte) (netip.Addr, error) {
+	if len(value) < 4 {
+		return netip.Addr{}, fmt.Errorf("address attribute too short")
+	}
+	var size int
+	switch value[1] {
+	case familyIPv4:
+		size = net.IPv4len
+	case familyIPv6:
+		size = net.IPv6len
+	default:
+		return netip.Addr{}, fmt.Errorf("unknown address family 0x%02x", value[1])
+	}
+	if len(value) < 4+size {
+		return netip.Addr{}, fmt.Errorf("address attribute too short")
+	}
+	ip := make([]byte, size)
+	copy(ip, value[4:4+size])
+	if key != nil {
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("invalid address in attribute")
+	}
+	return addr, nil
+}
@@ -0,0 +1,271 @@
+package stun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// attribute encodes a STUN attribute, padded to a multiple of 4 bytes.
+func attribute(attrType uint16, value []byte) []byte {
+	attr := make([]byte, 4, 4+len(value)+3)
+	binary.BigEndian.PutUint16(attr[0:2], attrType)
+	binary.BigEndian.PutUint16(attr[2:4], uint16(len(value)))
+	attr = append(attr, value...)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+	return attr
+}
+
+// addressValue encodes a (XOR-)MAPPED-ADDRESS value. When key is non-nil the
+// address is XORed with it.
+func addressValue(addr netip.Addr, port uint16, key []byte) []byte {
+	family := familyIPv4
+	if addr.Is6() {
+		family = familyIPv6
+	}
+	ip := addr.AsSlice()
+	if key != nil {
+		port ^= uint16(magicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	value := []byte{0, family, 0, 0}
+	binary.BigEndian.PutUint16(value[2:4], port)
+	return append(value, ip...)
+}
+
+// bindingResponse builds a binding success response with the given attributes.
+func bindingResponse(txID []byte, attrs ...[]byte) []byte {
+	body := bytes.Join(attrs, nil)
+	msg := make([]byte, headerLength, headerLength+len(body))
+	binary.BigEndian.PutUint16(msg[0:2], typeBindingResponse)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(body)))
+	binary.BigEndian.PutUint32(msg[4:8], magicCookie)
+	copy(msg[8:20], txID)
+	return append(msg, body...)
+}
+
+// xorKey returns the key XOR-MAPPED-ADDRESS values are XORed with.
+func xorKey(txID []byte) []byte {
+	key := binary.BigEndian.AppendUint32(nil, magicCookie)
+	return append(key, txID...)
+}
+
+func TestParseBindingResponse(t *testing.T) {
+	txID := []byte("0123456789ab")
+	v4 := netip.MustParseAddr("198.51.100.7")
+	v6 := netip.MustParseAddr("2001:db8::7")
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    netip.Addr
+		wantErr string
+	}{
+		{
+			name: "xor v4",
+			msg:  bindingResponse(txID, attribute(attrXORMappedAddress, addressValue(v4, 4242, xorKey(txID)))),
+			want: v4,
+		},
+		{
+			name: "xor v6",
+			msg:  bindingResponse(txID, attribute(attrXORMappedAddress, addressValue(v6, 4242, xorKey(txID)))),
+			want: v6,
+		},
+		{
+			name: "xor preferred over mapped",
+			msg: bindingResponse(txID,
+				attribute(attrMappedAddress, addressValue(netip.MustParseAddr("192.0.2.1"), 4242, nil)),
+				attribute(attrXORMappedAddress, addressValue(v4, 4242, xorKey(txID))),
+			),
+			want: v4,
+		},
+		{
+			name: "mapped fallback",
+			msg: bindingResponse(txID,
+				attribute(0x8022, []byte("software")),
+				attribute(attrMappedAddress, addressValue(v4, 4242, nil)),
+			),
+			want: v4,
+		},
+		{
+			name:    "transaction mismatch",
+			msg:     bindingResponse([]byte("ba9876543210"), attribute(attrXORMappedAddress, addressValue(v4, 4242, xorKey(txID)))),
+			wantErr: errTransactionMismatch.Error(),
+		},
+		{
+			name:    "too short",
+			msg:     []byte{0x01, 0x01},
+			wantErr: "response too short",
+		},
+		{
+			name: "wrong type",
+			msg: func() []byte {
+				msg := bindingResponse(txID)
+				binary.BigEndian.PutUint16(msg[0:2], 0x0111)
+				return msg
+			}(),
+			wantErr: "unexpected message type",
+		},
+		{
+			name: "message truncated",
+			msg: func() []byte {
+				msg := bindingResponse(txID, attribute(attrXORMappedAddress, addressValue(v4, 4242, xorKey(txID))))
+				return msg[:len(msg)-4]
+			}(),
+			wantErr: "response truncated",
+		},
+		{
+			name: "attribute truncated",
+			msg: func() []byte {
+				attr := attribute(attrXORMappedAddress, addressValue(v4, 4242, xorKey(txID)))
+				binary.BigEndian.PutUint16(attr[2:4], 64)
+				return bindingResponse(txID, attr)
+			}(),
+			wantErr: "attribute 0x0020 truncated",
+		},
+		{
+			name:    "no mapped address",
+			msg:     bindingResponse(txID, attribute(0x8022, []byte("software"))),
+			wantErr: "response has no mapped address",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBindingResponse(tt.msg, txID)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseBindingResponse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBindingResponse() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("parseBindingResponse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   []byte
+		key     []byte
+		want    netip.Addr
+		wantErr bool
+	}{
+		{name: "v4", value: []byte{0, familyIPv4, 0x10, 0x92, 192, 0, 2, 1}, want: netip.MustParseAddr("192.0.2.1")},
+		{name: "v4 xor", value: []byte{0, familyIPv4, 0x10, 0x92, 192 ^ 0x21, 0 ^ 0x12, 2 ^ 0xA4, 1 ^ 0x42}, key: xorKey(make([]byte, 12)), want: netip.MustParseAddr("192.0.2.1")},
+		{name: "v6", value: addressValue(netip.MustParseAddr("2001:db8::1"), 4242, nil), want: netip.MustParseAddr("2001:db8::1")},
+		{name: "too short", value: []byte{0, familyIPv4}, wantErr: true},
+		{name: "address too short", value: []byte{0, familyIPv6, 0x10, 0x92, 0x20, 0x01}, wantErr: true},
+		{name: "unknown family", value: []byte{0, 0x03, 0x10, 0x92, 192, 0, 2, 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAddress(tt.value, tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAddress() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAddress() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("parseAddress() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// responder runs a STUN server on the given local address, answering each
+// binding request with the address it came from. A response with the wrong
+// transaction ID is sent first, which clients must ignore.
+func responder(t *testing.T, network, address string) string {
+	t.Helper()
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Skipf("could not listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < headerLength || binary.BigEndian.Uint16(buf[0:2]) != typeBindingRequest {
+				continue
+			}
+			txID := bytes.Clone(buf[8:20])
+			udpAddr := from.(*net.UDPAddr).AddrPort()
+			mapped := addressValue(udpAddr.Addr().Unmap(), udpAddr.Port(), xorKey(txID))
+			conn.WriteTo(bindingResponse([]byte("ba9876543210"), attribute(attrXORMappedAddress, mapped)), from)
+			conn.WriteTo(bindingResponse(txID, attribute(attrXORMappedAddress, mapped)), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+		ipv6    bool
+		want    netip.Addr
+	}{
+		{name: "v4", network: "udp4", address: "127.0.0.1:0", want: netip.MustParseAddr("127.0.0.1")},
+		{name: "v6", network: "udp6", address: "[::1]:0", ipv6: true, want: netip.MustParseAddr("::1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := responder(t, tt.network, tt.address)
+			got, err := LookupIP([]string{server}, tt.ipv6)
+			if err != nil {
+				t.Fatalf("LookupIP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("LookupIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupIPFallback(t *testing.T) {
+	timeout := DefaultTimeout
+	DefaultTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { DefaultTimeout = timeout })
+
+	// nothing answers on the first server
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	server := responder(t, "udp4", "127.0.0.1:0")
+	got, err := LookupIP([]string{silent.LocalAddr().String(), server}, false)
+	if err != nil {
+		t.Fatalf("LookupIP() error = %v", err)
+	}
+	if want := netip.MustParseAddr("127.0.0.1"); got != want {
+		t.Fatalf("LookupIP() = %s, want %s", got, want)
+	}
+
+	// with no server answering, the lookup fails
+	if _, err := LookupIP([]string{silent.LocalAddr().String()}, false); err == nil {
+		t.Fatal("LookupIP() with no answering server did not fail")
+	}
+}
@@ -5,20 +5,35 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	muxer "github.com/traefik/traefik/v3/pkg/muxer/http"
-	"golang.org/x/net/publicsuffix"
+	"golang.org/x/net/idna"
 )
 
 type Domain string
 
+// hostnameProfile converts hostnames to the ascii (punycode) form used in DNS,
+// rejecting any that are not valid hostnames.
+var hostnameProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.ValidateLabels(true),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+)
+
 type TraefikRouter struct {
-	Name    string `json:"service"`
-	RuleStr string `json:"rule"`
-	Status  string `json:"status"`
+	Name        string   `json:"name"`
+	Service     string   `json:"service"`
+	Provider    string   `json:"provider"`
+	EntryPoints []string `json:"entryPoints"`
+	RuleStr     string   `json:"rule"`
+	Status      string   `json:"status"`
 }
 
-func (t *Traefik) GetDomains() ([]Domain, error) {
+// GetRouters returns all the HTTP routers known to the traefik instance.
+func (t *Traefik) GetRouters() ([]TraefikRouter, error) {
 	apiPath, err := url.JoinPath(t.URL, "/api/http/routers")
 	if err != nil {
 		return nil, fmt.Errorf("could not join url path for the http routers endpoint: %w", err)
@@ -35,15 +50,34 @@ func (t *Traefik) GetDomains() ([]Domain, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&routers); err != nil {
 		return nil, fmt.Errorf("could not decode routers response: %w", err)
 	}
+	return routers, nil
+}
+
+func (t *Traefik) GetDomains() ([]Domain, error) {
+	routers, err := t.GetRouters()
+	if err != nil {
+		return nil, err
+	}
 	domains := make([]Domain, 0)
 	for _, router := range routers {
-		ds, err := muxer.ParseDomains(router.RuleStr)
+		ds, err := router.Domains()
 		if err != nil {
-			return nil, fmt.Errorf("could not parse domains from rule: %w", err)
-		}
-		for _, d := range ds {
-			domains = append(domains, Domain(d))
+			return nil, err
 		}
+		domains = append(domains, ds...)
+	}
+	return domains, nil
+}
+
+// Domains returns the domains found in the host matchers of the router rule.
+func (r TraefikRouter) Domains() ([]Domain, error) {
+	ds, err := muxer.ParseDomains(r.RuleStr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse domains from rule: %w", err)
+	}
+	domains := make([]Domain, len(ds))
+	for i, d := range ds {
+		domains[i] = Domain(d)
 	}
 	return domains, nil
 }
@@ -52,6 +86,15 @@ func (d Domain) String() string {
 	return string(d)
 }
 
-func (d Domain) Root() (string, error) {
-	return publicsuffix.EffectiveTLDPlusOne(d.String())
+// Normalize returns the domain in the form used for DNS records. Unicode
+// (internationalised) domains are converted to punycode, the domain is
+// lowercased and any trailing dot is removed. An error is returned if the
+// domain is not a valid hostname, such as when a label is too long or has
+// characters that are not allowed.
+func (d Domain) Normalize() (Domain, error) {
+	ascii, err := hostnameProfile.ToASCII(strings.TrimSuffix(d.String(), "."))
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname %q: %w", d.String(), err)
+	}
+	return Domain(strings.ToLower(ascii)), nil
 }
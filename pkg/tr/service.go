@@ -0,0 +1,67 @@
+package tr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ServerStatusUp is the status traefik gives to servers passing health checks.
+const ServerStatusUp = "UP"
+
+type TraefikService struct {
+	Name         string            `json:"name"`
+	Provider     string            `json:"provider"`
+	Type         string            `json:"type"`
+	Status       string            `json:"status"`
+	ServerStatus map[string]string `json:"serverStatus"`
+	UsedBy       []string          `json:"usedBy"`
+}
+
+// GetServices returns all the HTTP services known to the traefik instance.
+func (t *Traefik) GetServices() ([]TraefikService, error) {
+	apiPath, err := url.JoinPath(t.URL, "/api/http/services")
+	if err != nil {
+		return nil, fmt.Errorf("could not join url path for the http services endpoint: %w", err)
+	}
+	resp, err := http.Get(apiPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch services from traefik: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch services from traefik: %s", resp.Status)
+	}
+	var services []TraefikService
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, fmt.Errorf("could not decode services response: %w", err)
+	}
+	return services, nil
+}
+
+// Healthy reports whether any server of the service is up. Services with no
+// server status (such as those without a health check, or that are not load
+// balancers) are taken to be healthy.
+func (s TraefikService) Healthy() bool {
+	if len(s.ServerStatus) == 0 {
+		return true
+	}
+	for _, status := range s.ServerStatus {
+		if strings.EqualFold(status, ServerStatusUp) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceName returns the full name of the service the router uses. Routers
+// may give the service without a provider, in which case it is from the same
+// provider as the router.
+func (r TraefikRouter) ServiceName() string {
+	if strings.Contains(r.Service, "@") || r.Provider == "" {
+		return r.Service
+	}
+	return r.Service + "@" + r.Provider
+}